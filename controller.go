@@ -17,15 +17,16 @@ limitations under the License.
 package main
 
 import (
-	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"reflect"
-	"strconv"
-	"strings"
 	"time"
 
+	"github.com/chiradeep/go-nitro/netscaler"
+	"github.com/prometheus/client_golang/prometheus"
 	"k8s.io/kubernetes/pkg/api"
 	"k8s.io/kubernetes/pkg/apis/extensions"
 	"k8s.io/kubernetes/pkg/client/cache"
@@ -33,7 +34,7 @@ import (
 	client "k8s.io/kubernetes/pkg/client/unversioned"
 	"k8s.io/kubernetes/pkg/controller/framework"
 	"k8s.io/kubernetes/pkg/runtime"
-	"k8s.io/kubernetes/pkg/util/sets"
+	"k8s.io/kubernetes/pkg/util/wait"
 	"k8s.io/kubernetes/pkg/watch"
 )
 
@@ -41,321 +42,175 @@ type StoreToIngressLister struct {
 	cache.Store
 }
 
-var priority = 10
-var knownEndpoints = make(map[string]map[string]string)
-var svcname_refcount = make(map[string]int)                // Reference count of NS full service name
-var ing_svcname_refcount = make(map[string]map[string]int) // Reference count of ingresses per kubernetes service
-
-func ingressRuleToPolicyName(namespace string, rule extensions.IngressRule) []string {
-	resultPolicyNames := []string{}
-	host := rule.Host
-	for _, path := range rule.HTTP.Paths {
-		path_ := path.Path
-		serviceName := path.Backend.ServiceName
-		servicePort := path.Backend.ServicePort.IntValue()
-		log.Printf("Ingress: Host: %s, path: %s, serviceName: %s, servicePort: %d", host, path_, serviceName, servicePort)
-		policyName := GeneratePolicyName(namespace, host, path_)
-		resultPolicyNames = append(resultPolicyNames, policyName)
-	}
-	return resultPolicyNames
-}
-
-func ingressToPolicyNames(ingress *extensions.Ingress) []string {
-	resultPolicyNames := []string{}
-	namespace := ingress.Namespace
-	for _, rule := range ingress.Spec.Rules {
-		policyNames := ingressRuleToPolicyName(namespace, rule)
-		resultPolicyNames = append(resultPolicyNames, policyNames...)
-	}
-	return resultPolicyNames
+type StoreToSecretLister struct {
+	cache.Store
 }
 
-// Pass ports=nil for all ports.
-func formatEndpoints(endpoints *api.Endpoints, ports sets.String) string {
-	if len(endpoints.Subsets) == 0 {
-		return "<none>"
-	}
-	list := []string{}
-	for i := range endpoints.Subsets {
-		ss := &endpoints.Subsets[i]
-		for i := range ss.Ports {
-			port := &ss.Ports[i]
-			if ports == nil || ports.Has(port.Name) {
-				for i := range ss.Addresses {
-					addr := &ss.Addresses[i]
-					list = append(list, fmt.Sprintf("%s:%d", addr.IP, port.Port))
-				}
-			}
-		}
+func ingressListFunc(c *client.Client, ns string) func(api.ListOptions) (runtime.Object, error) {
+	return func(opts api.ListOptions) (runtime.Object, error) {
+		return c.Extensions().Ingress(ns).List(opts)
 	}
-	ret := strings.Join(list, ",")
-	return ret
 }
 
-func ingressToNetscalerConfig(kubeClient *client.Client, csvserverName string, ingress *extensions.Ingress, priority int,
-	knownEndpoints map[string]map[string]string, svcname_refcount map[string]int,
-	ing_svcname_refcount map[string]map[string]int) int {
-
-	for _, rule := range ingress.Spec.Rules {
-		host := rule.Host
-		namespace := ingress.Namespace
-		thisIngEndpoints := make(map[string]string)
-                lbNameMap := make(map[string]int)
-		var lbName string
-		for _, path := range rule.HTTP.Paths {
-			path_ := path.Path
-			serviceName := path.Backend.ServiceName
-			servicePort := path.Backend.ServicePort.IntValue()
-			policyName := GeneratePolicyName(namespace, host, path_)
-			existing := ListBoundPolicy(csvserverName, policyName)
-			if len(existing) != 0 {
-				log.Printf("Ingress: Policy already exists: %s, Host: %s, path: %s, serviceName: %s, servicePort: %d", policyName, host, path_, serviceName, servicePort)
-				continue
-			}
-
-			// Find endpoints
-			endpoints, err := kubeClient.Endpoints(api.NamespaceDefault).Get(serviceName)
-			if err != nil {
-				log.Printf("Failed to retrieve endpoints for service %s", serviceName)
-				continue
-			}
-			endpoints_all := formatEndpoints(endpoints, nil)
-
-			endpoints_split := strings.Split(endpoints_all, ",")
-			for _, ep := range endpoints_split {
-				ep_ip_port := strings.Split(ep, ":")
-				serviceIp := ep_ip_port[0]
-				servicePort, err := strconv.Atoi(ep_ip_port[1])
-				if err != nil {
-					log.Printf("Failed to convert endpoint port to integer %s", ep_ip_port[1])
-					continue
-				}
-
-				serviceName_mod := "svc_" + serviceName + "_" + strings.Replace(serviceIp, ".", "_", -1) + "_" + ep_ip_port[1]
-				thisIngEndpoints[ep] = serviceName_mod
-
-				log.Printf("Configure Netscaler: policy: %s Ingress Host: %s, path: %s, serviceName: %s, serviceIp: %s servicePort: %d priority %d", policyName, host, path_, serviceName, serviceIp, servicePort, priority)
-				lbName = ConfigureContentVServer(namespace, csvserverName, host, path_, serviceIp, serviceName_mod, servicePort, priority, svcname_refcount)
-				lbNameMap[lbName] = 1
-			}
-			priority += 10
-			knownEndpoints[serviceName] = thisIngEndpoints
-			ing_svcname_refcount[serviceName] = lbNameMap
-		}
+func ingressWatchFunc(c *client.Client, ns string) func(options api.ListOptions) (watch.Interface, error) {
+	return func(options api.ListOptions) (watch.Interface, error) {
+		return c.Extensions().Ingress(ns).Watch(options)
 	}
-
-	//fmt.Println("DBG knownEndpoints map : ", knownEndpoints, svcname_refcount, ing_svcname_refcount, lbNameMap)
-	return priority
 }
 
-func createContentVserverForIngress(ing *extensions.Ingress) (string, error) {
-	csvserverName := GenerateCsVserverName(ing.Namespace, ing.Name)
-	if FindContentVserver(csvserverName) {
-		return csvserverName, nil
-	}
-	protocol, ok := ing.Annotations["protocol"]
-	if !ok {
-		protocol = "HTTP"
-	}
-	port, ok := ing.Annotations["port"]
-	if !ok {
-		port = "80"
-	}
-	intPort, err := strconv.Atoi(port)
-	if err != nil {
-		log.Printf("Failed to parse port annotation for ingress %s", ing.Name)
-		return "", errors.New("Failed to parse port annotation for ingress " + ing.Name)
-	}
-	publicIP, ok := ing.Annotations["publicIP"]
-	if !ok {
-		log.Printf("Failed to retrieve annotation publicIP for ingress %s, skipping processing", ing.Name)
-		return "", errors.New("Failed to retrieve annotation publicIP for ingress " + ing.Name)
-	}
-	err = CreateContentVServer(csvserverName, publicIP, intPort, protocol)
-	if err != nil {
-		return "", errors.New("Failed to create content vserver " + csvserverName + " for ingress " + ing.Name)
+func svcListFunc(c *client.Client, ns string) func(api.ListOptions) (runtime.Object, error) {
+	return func(opts api.ListOptions) (runtime.Object, error) {
+		return c.Services(ns).List(opts)
 	}
-	return csvserverName, nil
 }
 
-/* Function to see if the endpoints have changed for a given ingress. If so the
- * NS serivices associated with them should be added or removed depending on
- * whether the endpoint is newly seen or a previous endpoint is no longer
- * avaialble.
- */
-func updateEndpoints(knownEndpoints map[string]string,
-	thisIngKnownEndpoints map[string]string,
-	ingServiceName string,
-	svcname_refcount map[string]int) {
-	// Find the items in known and not in new - Delete services
-	for knownEpIP, sname := range knownEndpoints {
-		_, prs := thisIngKnownEndpoints[knownEpIP]
-		if prs == false {
-			//Delete the Netscaler Services
-			DeleteService(sname)
-			serviceName_mod := "svc_" + ingServiceName + "_" + strings.Replace(knownEpIP, ".", "_", -1)
-			serviceName_mod = strings.Replace(serviceName_mod, ":", "_", -1)
-			_, present := svcname_refcount[serviceName_mod]
-			if present {
-				delete(svcname_refcount, serviceName_mod)
-			}
-		}
-	}
-
-	// Find the items in new and not in known - Add services
-	for newEpIP, sname := range thisIngKnownEndpoints {
-		_, prs := knownEndpoints[newEpIP]
-		if prs == false {
-			//Add Netscaler Service
-			lbNames_map := ing_svcname_refcount[ingServiceName]
-			for lbName, _ := range lbNames_map {
-				AddAndBindService(lbName, sname, newEpIP)
-				serviceName_mod := "svc_" + ingServiceName + "_" + strings.Replace(newEpIP, ".", "_", -1)
-				serviceName_mod = strings.Replace(serviceName_mod, ":", "_", -1)
-				_, present := svcname_refcount[serviceName_mod]
-				if present {
-					svcname_refcount[serviceName_mod]++
-				} else {
-					svcname_refcount[serviceName_mod] = 1
-				}
-			}
-		}
+func svcWatchFunc(c *client.Client, ns string) func(options api.ListOptions) (watch.Interface, error) {
+	return func(options api.ListOptions) (watch.Interface, error) {
+		return c.Services(ns).Watch(options)
 	}
 }
 
-func addIngress(kubeClient *client.Client, ing *extensions.Ingress) {
-	log.Printf("inner loop: current priority: %d", priority)
-	csvserverName, err := createContentVserverForIngress(ing)
-	if err != nil {
-		log.Printf("Unable to create / retrieve content vserver for ingress %s; skipping", ing.Name)
-		return
-	}
-
-	_, priorities := ListBoundPolicies(csvserverName)
-	if len(priorities) > 0 {
-		priority = priorities[len(priorities)-1] + 10
+func epListFunc(c *client.Client, ns string) func(api.ListOptions) (runtime.Object, error) {
+	return func(opts api.ListOptions) (runtime.Object, error) {
+		return c.Endpoints(ns).List(opts)
 	}
-	priority = ingressToNetscalerConfig(kubeClient, csvserverName, ing, priority, knownEndpoints, svcname_refcount, ing_svcname_refcount)
-	//fmt.Println("DBG svcref map ADD  : ", knownEndpoints, svcname_refcount, ing_svcname_refcount, lbNameMap)
 }
 
-func delIngress(kubeClient *client.Client, ing *extensions.Ingress) {
-	csvserverName := GenerateCsVserverName(ing.Namespace, ing.Name)
-	for _, rule := range ing.Spec.Rules {
-		for _, path := range rule.HTTP.Paths {
-			serviceName := path.Backend.ServiceName
-			DeleteContentVServer(csvserverName, svcname_refcount, ing_svcname_refcount[serviceName])
-			lbName_map := ing_svcname_refcount[serviceName]
-			if len(lbName_map) == 0 {
-				delete(ing_svcname_refcount, serviceName)
-				delete(knownEndpoints, serviceName)
-			}
-		}
+func epWatchFunc(c *client.Client, ns string) func(options api.ListOptions) (watch.Interface, error) {
+	return func(options api.ListOptions) (watch.Interface, error) {
+		return c.Endpoints(ns).Watch(options)
 	}
-	//fmt.Println("DBG svcref map DEL  : ", knownEndpoints, svcname_refcount, ing_svcname_refcount, lbNameMap)
 }
 
-func ingressListFunc(c *client.Client, ns string) func(api.ListOptions) (runtime.Object, error) {
+func secretListFunc(c *client.Client, ns string) func(api.ListOptions) (runtime.Object, error) {
 	return func(opts api.ListOptions) (runtime.Object, error) {
-		return c.Extensions().Ingress(ns).List(opts)
+		return c.Secrets(ns).List(opts)
 	}
 }
 
-func ingressWatchFunc(c *client.Client, ns string) func(options api.ListOptions) (watch.Interface, error) {
+func secretWatchFunc(c *client.Client, ns string) func(options api.ListOptions) (watch.Interface, error) {
 	return func(options api.ListOptions) (watch.Interface, error) {
-		return c.Extensions().Ingress(ns).Watch(options)
+		return c.Secrets(ns).Watch(options)
 	}
 }
 
-func epListFunc(c *client.Client, ns string) func(api.ListOptions) (runtime.Object, error) {
+func podListFunc(c *client.Client, ns string) func(api.ListOptions) (runtime.Object, error) {
 	return func(opts api.ListOptions) (runtime.Object, error) {
-		return c.Endpoints(ns).List(opts)
+		return c.Pods(ns).List(opts)
 	}
 }
 
-func epWatchFunc(c *client.Client, ns string) func(options api.ListOptions) (watch.Interface, error) {
+func podWatchFunc(c *client.Client, ns string) func(options api.ListOptions) (watch.Interface, error) {
 	return func(options api.ListOptions) (watch.Interface, error) {
-		return c.Endpoints(ns).Watch(options)
+		return c.Pods(ns).Watch(options)
 	}
 }
 
-func startControllers(kubeClient *client.Client) {
+func startControllers(kubeClient *client.Client, elector *leaderElector, classFilter ingressClassFilter, vips *vipAllocator,
+	statsInterval time.Duration, servicegroups bool, drainTimeout time.Duration, defaultBackend *defaultBackend) {
 	var ingController *framework.Controller
+	var svcController *framework.Controller
 	var epController *framework.Controller
+	var secretController *framework.Controller
+	var podController *framework.Controller
 	var ingLister StoreToIngressLister
+	var svcLister cache.StoreToServiceLister
 	var epLister cache.StoreToEndpointsLister
+	var secretLister StoreToSecretLister
+	var podLister cache.StoreToPodLister
+	var r *reconciler
 	resyncPeriod := 10 * time.Second
+	reconcilePeriod := 60 * time.Second
+	workers := 4
+	maxRetries := 10
+
+	// Ingress, service, endpoints and secret events are reduced to the keys of the
+	// affected ingresses, the workers reconcile them once the stores are
+	// filled. Events affecting an ingress also outdate the cached desired
+	// configuration.
+	queue := newRateLimitedQueue(time.Second, 5*time.Minute)
+	registerQueueDepth(queue)
+	enqueueIngress := func(obj interface{}) {
+		key, err := framework.DeletionHandlingMetaNamespaceKeyFunc(obj)
+		if err != nil {
+			log.Printf("Couldn't get key for object %+v: %v", obj, err)
+			return
+		}
+		r.configs.Invalidate()
+		queue.Add(key)
+	}
+	enqueueBackend := func(obj interface{}) {
+		key, err := framework.DeletionHandlingMetaNamespaceKeyFunc(obj)
+		if err != nil {
+			log.Printf("Couldn't get key for object %+v: %v", obj, err)
+			return
+		}
+		namespace, name, _ := cache.SplitMetaNamespaceKey(key)
+		keys := r.ingressesForService(namespace, name)
+		if len(keys) > 0 {
+			r.configs.Invalidate()
+		}
+		for _, key := range keys {
+			queue.Add(key)
+		}
+	}
+	enqueueSecret := func(obj interface{}) {
+		key, err := framework.DeletionHandlingMetaNamespaceKeyFunc(obj)
+		if err != nil {
+			log.Printf("Couldn't get key for object %+v: %v", obj, err)
+			return
+		}
+		namespace, name, _ := cache.SplitMetaNamespaceKey(key)
+		keys := r.ingressesForSecret(namespace, name)
+		if len(keys) > 0 {
+			r.configs.Invalidate()
+		}
+		for _, key := range keys {
+			queue.Add(key)
+		}
+	}
 
+	// Ingresses of other ingress classes are ignored, unless they just moved
+	// away from ours and their configuration has to be removed
+	ours := func(obj interface{}) bool {
+		ing, ok := obj.(*extensions.Ingress)
+		return !ok || classFilter.Matches(ing)
+	}
 	ingHandlers := framework.ResourceEventHandlerFuncs{
 		AddFunc: func(obj interface{}) {
-			addIng := obj.(*extensions.Ingress)
-			addIngress(kubeClient, addIng)
+			if ours(obj) {
+				enqueueIngress(obj)
+			}
 		},
 		DeleteFunc: func(obj interface{}) {
-			delIng := obj.(*extensions.Ingress)
-			delIngress(kubeClient, delIng)
+			if ours(obj) {
+				enqueueIngress(obj)
+			}
 		},
-	}
-
-	epHandlers := framework.ResourceEventHandlerFuncs{
-		AddFunc: func(obj interface{}) {
-			addEP := obj.(*api.Endpoints)
-			endpoints_all := formatEndpoints(addEP, nil)
-			_, found := ing_svcname_refcount[addEP.Name]
-			if found {
-				thisIngEndpoints := make(map[string]string)
-				endpoints_split := strings.Split(endpoints_all, ",")
-				for _, ep := range endpoints_split {
-					ep_ip_port := strings.Split(ep, ":")
-					serviceIp := ep_ip_port[0]
-					serviceName_mod := "svc_" + addEP.Name + "_" + strings.Replace(serviceIp, ".", "_", -1) + "_" + ep_ip_port[1]
-					thisIngEndpoints[ep] = serviceName_mod
-				}
-				knownEndpoints[addEP.Name] = thisIngEndpoints
+		UpdateFunc: func(old, cur interface{}) {
+			if !reflect.DeepEqual(old, cur) && (ours(old) || ours(cur)) {
+				enqueueIngress(cur)
 			}
-			//fmt.Println("DBG knownEndpoints map : ", knownEndpoints, svcname_refcount, ing_svcname_refcount, lbNameMap)
 		},
-		DeleteFunc: func(obj interface{}) {
-			delEP := obj.(*api.Endpoints)
-			endpoints_all := formatEndpoints(delEP, nil)
-			_, found := ing_svcname_refcount[delEP.Name]
-			if found {
-				if endpoints_all == "<none>" {
-					delete(knownEndpoints, delEP.Name)
-				} else {
-					thisIngEndpoints := make(map[string]string)
-					endpoints_split := strings.Split(endpoints_all, ",")
-					for _, ep := range endpoints_split {
-						ep_ip_port := strings.Split(ep, ":")
-						serviceIp := ep_ip_port[0]
-						serviceName_mod := "svc_" + delEP.Name + "_" + strings.Replace(serviceIp, ".", "_", -1) + "_" + ep_ip_port[1]
-						thisIngEndpoints[ep] = serviceName_mod
-					}
-					knownEndpoints[delEP.Name] = thisIngEndpoints
-				}
+	}
+
+	// Services and endpoints share their namespace and name
+	backendHandlers := framework.ResourceEventHandlerFuncs{
+		AddFunc:    enqueueBackend,
+		DeleteFunc: enqueueBackend,
+		UpdateFunc: func(old, cur interface{}) {
+			if !reflect.DeepEqual(old, cur) {
+				enqueueBackend(cur)
 			}
-			//fmt.Println("DBG knownEndpoints map : ", knownEndpoints, svcname_refcount, ing_svcname_refcount, lbNameMap)
 		},
+	}
+
+	secretHandlers := framework.ResourceEventHandlerFuncs{
+		AddFunc:    enqueueSecret,
+		DeleteFunc: enqueueSecret,
 		UpdateFunc: func(old, cur interface{}) {
 			if !reflect.DeepEqual(old, cur) {
-				upEP := cur.(*api.Endpoints)
-				endpoints_all := formatEndpoints(upEP, nil)
-				_, found := ing_svcname_refcount[upEP.Name]
-				if found {
-					thisIngEndpoints := make(map[string]string)
-					if endpoints_all != "<none>" {
-						endpoints_split := strings.Split(endpoints_all, ",")
-						for _, ep := range endpoints_split {
-							ep_ip_port := strings.Split(ep, ":")
-							serviceIp := ep_ip_port[0]
-							serviceName_mod := "svc_" + upEP.Name + "_" + strings.Replace(serviceIp, ".", "_", -1) + "_" + ep_ip_port[1]
-							thisIngEndpoints[ep] = serviceName_mod
-						}
-					}
-					updateEndpoints(knownEndpoints[upEP.Name], thisIngEndpoints, upEP.Name, svcname_refcount)
-					knownEndpoints[upEP.Name] = thisIngEndpoints
-				}
-				//fmt.Println("DBG knownEndpoints map : ", knownEndpoints, svcname_refcount, ing_svcname_refcount, lbNameMap)
+				enqueueSecret(cur)
 			}
 		},
 	}
@@ -367,21 +222,119 @@ func startControllers(kubeClient *client.Client) {
 		},
 		&extensions.Ingress{}, resyncPeriod, ingHandlers)
 
+	svcLister.Store, svcController = framework.NewInformer(
+		&cache.ListWatch{
+			ListFunc:  svcListFunc(kubeClient, api.NamespaceAll),
+			WatchFunc: svcWatchFunc(kubeClient, api.NamespaceAll),
+		},
+		&api.Service{}, resyncPeriod, backendHandlers)
+
 	epLister.Store, epController = framework.NewInformer(
 		&cache.ListWatch{
 			ListFunc:  epListFunc(kubeClient, api.NamespaceAll),
 			WatchFunc: epWatchFunc(kubeClient, api.NamespaceAll),
 		},
-		&api.Endpoints{}, resyncPeriod, epHandlers)
+		&api.Endpoints{}, resyncPeriod, backendHandlers)
+
+	secretLister.Store, secretController = framework.NewInformer(
+		&cache.ListWatch{
+			ListFunc:  secretListFunc(kubeClient, api.NamespaceAll),
+			WatchFunc: secretWatchFunc(kubeClient, api.NamespaceAll),
+		},
+		&api.Secret{}, resyncPeriod, secretHandlers)
+
+	// Pods are only looked up for their probes. A changed probe comes with
+	// new pods and thus with changed endpoints, so pod events are ignored.
+	podLister.Store, podController = framework.NewInformer(
+		&cache.ListWatch{
+			ListFunc:  podListFunc(kubeClient, api.NamespaceAll),
+			WatchFunc: podWatchFunc(kubeClient, api.NamespaceAll),
+		},
+		&api.Pod{}, resyncPeriod, framework.ResourceEventHandlerFuncs{})
+
+	r = newReconciler(kubeClient, ingLister, svcLister, epLister, secretLister, podLister, classFilter, vips, servicegroups, drainTimeout, defaultBackend)
 
 	stop := make(chan struct{})
 	go ingController.Run(stop)
+	go svcController.Run(stop)
 	go epController.Run(stop)
+	go secretController.Run(stop)
+	go podController.Run(stop)
+
+	// Until all stores are filled the desired configuration is incomplete
+	wait.PollInfinite(time.Second, func() (bool, error) {
+		return ingController.HasSynced() && svcController.HasSynced() && epController.HasSynced() &&
+			secretController.HasSynced() && podController.HasSynced(), nil
+	})
+
+	// Only the leader writes to the NetScaler, standby replicas keep their
+	// stores and queue filled. The periodic reconcile runs right away, so a
+	// new leader starts with a full reconcile.
+	lead := func() {
+		if vips.Enabled() {
+			wait.PollInfinite(time.Second, func() (bool, error) {
+				if err := vips.Load(); err != nil {
+					log.Printf("Failed to load VIP allocations: %v", err)
+					return false, nil
+				}
+				return true, nil
+			})
+			r.configs.Invalidate()
+		}
+		syncIngress := func(key string) error {
+			start := time.Now()
+			err := r.syncIngress(key)
+			observeReconcile("sync", start, err)
+			return err
+		}
+		for i := 0; i < workers; i++ {
+			go processQueue(queue, syncIngress, maxRetries)
+		}
+		if statsInterval > 0 {
+			stats := newStatsCollector()
+			go wait.Until(func() { stats.Poll(r) }, statsInterval, stop)
+		}
+		wait.Until(func() {
+			for _, key := range r.ingressKeys() {
+				queue.Add(key)
+			}
+			start := time.Now()
+			err := r.collectGarbage()
+			observeReconcile("gc", start, err)
+			if err != nil {
+				log.Printf("Failed to remove stale configuration: %v", err)
+			}
+		}, reconcilePeriod, stop)
+	}
+	if elector == nil {
+		go lead()
+	} else {
+		go func() {
+			elector.Run(lead)
+			// Another replica may take over any moment now, bail out
+			// instead of racing it and rejoin as a standby after a restart
+			log.Fatalf("Lost the leader lease, exiting")
+		}()
+	}
 	<-stop
+	queue.ShutDown()
 	log.Printf("ABK Exiting")
 }
 
 func main() {
+	leaderElect := flag.Bool("leader-elect", false, "Elect a leader among the controller replicas, only the leader configures the NetScaler")
+	leaderElectLock := flag.String("leader-elect-lock", "kube-system/citrix-ingress-controller", "Namespace/name of the Endpoints object holding the leader lease")
+	ingressClass := flag.String("ingress-class", "citrix", "Only configure ingresses whose kubernetes.io/ingress.class annotation has this value")
+	claimClassless := flag.Bool("claim-classless-ingresses", true, "Also configure ingresses without a kubernetes.io/ingress.class annotation")
+	vipPools := flag.String("vip-pools", "", "Comma separated CIDRs to allocate VIPs from for ingresses without a publicIP annotation")
+	vipAllocations := flag.String("vip-allocations", "kube-system/citrix-ingress-vips", "Namespace/name of the ConfigMap holding the VIP allocations")
+	metricsAddress := flag.String("metrics-address", ":8080", "Address to serve Prometheus metrics on at /metrics, empty to disable")
+	statsInterval := flag.Duration("stats-interval", 30*time.Second, "Interval to poll NetScaler statistics of the managed objects at, 0 to disable")
+	servicegroups := flag.Bool("servicegroups", false, "Load balance the endpoints of each backend as members of one servicegroup instead of a service per endpoint")
+	drainTimeout := flag.Duration("drain-timeout", 30*time.Second, "How long endpoints that are no longer ready take no new connections before they are removed, unless the ingress sets netscaler/drain-timeout")
+	defaultBackendService := flag.String("default-backend-service", "", "Namespace/name:port of the service receiving requests that match no rule, for ingresses without a backend of their own")
+	flag.Parse()
+
 	// Prefer environment variables and otherwise try accessing APIserver directly
 	var kubeClient *client.Client
 	var err error
@@ -400,14 +353,54 @@ func main() {
 	if err != nil {
 		log.Fatalln("Can't connect to Kubernetes API:", err)
 	}
+	if _, err := netscaler.NewNitroClientFromEnv(); err != nil {
+		log.Fatalln("Can't configure NetScaler:", err)
+	}
 
-	// Performing cleanup - start with a clean NS config. Handle situations where
-	// k8s cluster has changed while NS has stale configuration.
-	var existingCsVservers = sets.NewString()
-	existingCsVservers.Insert(ListContentVservers()...)
-	for _, csvserver := range existingCsVservers.List() {
-		DeleteContentVServer(csvserver, svcname_refcount, nil)
+	if *metricsAddress != "" {
+		http.Handle("/metrics", prometheus.Handler())
+		go func() {
+			log.Fatalln("Can't serve metrics:", http.ListenAndServe(*metricsAddress, nil))
+		}()
+	}
+
+	var elector *leaderElector
+	if *leaderElect {
+		namespace, name, err := cache.SplitMetaNamespaceKey(*leaderElectLock)
+		if err != nil || namespace == "" {
+			log.Fatalln("Invalid leader election lock:", *leaderElectLock)
+		}
+		identity, err := os.Hostname()
+		if err != nil {
+			log.Fatalln("Can't determine leader election identity:", err)
+		}
+		elector = newLeaderElector(kubeClient, namespace, name, identity)
+	}
+
+	var vips *vipAllocator
+	if *vipPools != "" {
+		pools, err := parseVipPools(*vipPools)
+		if err != nil {
+			log.Fatalln("Invalid VIP pools:", err)
+		}
+		namespace, name, err := cache.SplitMetaNamespaceKey(*vipAllocations)
+		if err != nil || namespace == "" {
+			log.Fatalln("Invalid VIP allocations ConfigMap:", *vipAllocations)
+		}
+		vips = newVipAllocator(kubeClient, namespace, name, pools)
+	}
+
+	var backend *defaultBackend
+	if *defaultBackendService != "" {
+		backend, err = parseDefaultBackend(*defaultBackendService)
+		if err != nil {
+			log.Fatalln("Invalid default backend service:", err)
+		}
 	}
 
-	startControllers(kubeClient)
+	// Configuration left on the NetScaler by an earlier run is not cleared,
+	// the first reconcile adopts what is still desired and the garbage
+	// collection removes the rest, without interrupting traffic.
+	startControllers(kubeClient, elector, ingressClassFilter{Class: *ingressClass, ClaimClassless: *claimClassless}, vips,
+		*statsInterval, *servicegroups, *drainTimeout, backend)
 }
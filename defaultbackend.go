@@ -0,0 +1,55 @@
+/*
+Copyright 2016 Citrix Systems, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	"k8s.io/kubernetes/pkg/client/cache"
+	"k8s.io/kubernetes/pkg/util/intstr"
+)
+
+// defaultBackend is the service requests that match no rule are sent to, for
+// ingresses that don't name a backend of their own.
+type defaultBackend struct {
+	Namespace string
+	Backend   extensions.IngressBackend
+}
+
+// parseDefaultBackend parses a service given as namespace/name:port, the port
+// being the number or name of a port of the service. The port defaults to 80.
+func parseDefaultBackend(s string) (*defaultBackend, error) {
+	key, port := s, "80"
+	if i := strings.LastIndex(s, ":"); i >= 0 {
+		key, port = s[:i], s[i+1:]
+	}
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil || namespace == "" || name == "" || port == "" {
+		return nil, fmt.Errorf("%q is not of the form namespace/name:port", s)
+	}
+	servicePort := intstr.FromString(port)
+	if number, err := strconv.Atoi(port); err == nil {
+		servicePort = intstr.FromInt(number)
+	}
+	return &defaultBackend{
+		Namespace: namespace,
+		Backend:   extensions.IngressBackend{ServiceName: name, ServicePort: servicePort},
+	}, nil
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2016 Citrix Systems, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"k8s.io/kubernetes/pkg/apis/extensions"
+)
+
+// drainTimeoutAnnotation sets how many seconds endpoints of the backends of an
+// ingress are drained before they are removed.
+const drainTimeoutAnnotation = "netscaler/drain-timeout"
+
+// States the NetScaler reports for services and servicegroup members that
+// were disabled.
+const (
+	stateOutOfService          = "OUT OF SERVICE"
+	stateGoingOutOfService     = "GOING OUT OF SERVICE"
+	stateDownGoingOutOfService = "DOWN WHEN GOING OUT OF SERVICE"
+)
+
+func isDisabled(state string) bool {
+	return state == stateOutOfService || state == stateGoingOutOfService || state == stateDownGoingOutOfService
+}
+
+// ingressDrainTimeout returns the drain timeout the ingress asks for, or
+// defaultTimeout.
+func ingressDrainTimeout(ing *extensions.Ingress, defaultTimeout time.Duration) (time.Duration, error) {
+	value, ok := ing.Annotations[drainTimeoutAnnotation]
+	if !ok {
+		return defaultTimeout, nil
+	}
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < 0 {
+		return defaultTimeout, invalidAnnotation(drainTimeoutAnnotation, value)
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// drainTracker remembers when endpoints that are no longer ready started
+// draining. Endpoints are disabled gracefully, so that they take no new
+// connections while the open ones complete, and are removed once they are
+// out of service or their drain timeout passed.
+type drainTracker struct {
+	mu       sync.Mutex
+	draining map[string]drainState
+}
+
+type drainState struct {
+	start   time.Time
+	timeout time.Duration
+}
+
+func newDrainTracker() *drainTracker {
+	return &drainTracker{draining: make(map[string]drainState)}
+}
+
+// Drain disables the endpoint with the given key and state through disable,
+// which is passed the delay in seconds the NetScaler waits at most for open
+// connections. It reports true once the endpoint can be removed. The timeout
+// of the first call for an endpoint applies until it is drained.
+func (d *drainTracker) Drain(key string, state string, timeout time.Duration, disable func(delay int) error) (bool, error) {
+	if timeout <= 0 || state == stateOutOfService {
+		d.Forget(key)
+		return true, nil
+	}
+	d.mu.Lock()
+	current, ok := d.draining[key]
+	if !ok {
+		current = drainState{start: time.Now(), timeout: timeout}
+		d.draining[key] = current
+	}
+	d.mu.Unlock()
+
+	if time.Since(current.start) >= current.timeout {
+		d.Forget(key)
+		return true, nil
+	}
+	if !isDisabled(state) {
+		log.Printf("Draining %s for up to %s", key, current.timeout)
+		delay := int((current.timeout + time.Second - 1) / time.Second)
+		if err := disable(delay); err != nil {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// Forget stops tracking the endpoint, after it was removed or became ready
+// again.
+func (d *drainTracker) Forget(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.draining, key)
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2016 Citrix Systems, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+)
+
+func TestDrain(t *testing.T) {
+	tests := []struct {
+		name       string
+		state      string
+		timeout    time.Duration
+		disableErr error
+		// delay is the delay disable is called with, -1 if not called
+		delay   int
+		drained bool
+		wantErr bool
+	}{
+		{name: "up", state: "UP", timeout: 30 * time.Second, delay: 30},
+		{name: "down", state: "DOWN", timeout: 30 * time.Second, delay: 30},
+		{name: "delay rounded up", state: "UP", timeout: 1500 * time.Millisecond, delay: 2},
+		{name: "going out of service", state: stateGoingOutOfService, timeout: 30 * time.Second, delay: -1},
+		{name: "down going out of service", state: stateDownGoingOutOfService, timeout: 30 * time.Second, delay: -1},
+		{name: "out of service", state: stateOutOfService, timeout: 30 * time.Second, delay: -1, drained: true},
+		{name: "no timeout", state: "UP", timeout: 0, delay: -1, drained: true},
+		{name: "disable fails", state: "UP", timeout: 30 * time.Second, disableErr: errors.New("failed"), delay: 30, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		d := newDrainTracker()
+		delay := -1
+		drained, err := d.Drain("svc", tt.state, tt.timeout, func(seconds int) error {
+			delay = seconds
+			return tt.disableErr
+		})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: Drain() error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		if drained != tt.drained {
+			t.Errorf("%s: Drain() = %v, want %v", tt.name, drained, tt.drained)
+		}
+		if delay != tt.delay {
+			t.Errorf("%s: disabled with delay %d, want %d", tt.name, delay, tt.delay)
+		}
+		if _, tracked := d.draining["svc"]; tracked == tt.drained {
+			t.Errorf("%s: tracked = %v after Drain() = %v", tt.name, tracked, drained)
+		}
+	}
+}
+
+func TestDrainTimeout(t *testing.T) {
+	d := newDrainTracker()
+	noop := func(int) error { return nil }
+	if drained, _ := d.Drain("svc", "UP", 10*time.Millisecond, noop); drained {
+		t.Fatalf("Drain() = true before the timeout passed")
+	}
+	time.Sleep(20 * time.Millisecond)
+	// The timeout of the first call applies until the endpoint is drained
+	if drained, _ := d.Drain("svc", stateGoingOutOfService, time.Hour, noop); !drained {
+		t.Errorf("Drain() = false after the timeout passed")
+	}
+	if _, tracked := d.draining["svc"]; tracked {
+		t.Errorf("drained endpoint is still tracked")
+	}
+
+	// An endpoint that became ready again starts over
+	d.Drain("svc", "UP", 10*time.Millisecond, noop)
+	d.Forget("svc")
+	time.Sleep(20 * time.Millisecond)
+	if drained, _ := d.Drain("svc", "UP", time.Hour, noop); drained {
+		t.Errorf("Drain() = true for an endpoint forgotten before its timeout")
+	}
+}
+
+func TestIngressDrainTimeout(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"", 30 * time.Second, false},
+		{"0", 0, false},
+		{"120", 2 * time.Minute, false},
+		{"-1", 30 * time.Second, true},
+		{"2m", 30 * time.Second, true},
+	}
+
+	for _, tt := range tests {
+		ing := &extensions.Ingress{}
+		if tt.value != "" {
+			ing.ObjectMeta = api.ObjectMeta{Annotations: map[string]string{drainTimeoutAnnotation: tt.value}}
+		}
+		got, err := ingressDrainTimeout(ing, 30*time.Second)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ingressDrainTimeout(%q) error = %v, want error %v", tt.value, err, tt.wantErr)
+		}
+		if got != tt.want {
+			t.Errorf("ingressDrainTimeout(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2016 Citrix Systems, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/unversioned"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	client "k8s.io/kubernetes/pkg/client/unversioned"
+)
+
+// Event reasons recorded on ingresses
+const (
+	reasonCreatedCSVserver     = "CreatedCSVserver"
+	reasonPolicyBound          = "PolicyBound"
+	reasonMissingPublicIP      = "MissingPublicIP"
+	reasonEndpointLookupFailed = "EndpointLookupFailed"
+	reasonSecretLookupFailed   = "SecretLookupFailed"
+	reasonVIPConflict          = "VIPConflict"
+	reasonNitroError           = "NitroError"
+	reasonSyncFailed           = "SyncFailed"
+	reasonInvalidIngress       = "InvalidIngress"
+	reasonInvalidAnnotation    = "InvalidAnnotation"
+)
+
+// maxRecordedEvents bounds the events remembered for aggregation.
+const maxRecordedEvents = 4096
+
+// eventRecorder records Events on ingresses. An event repeating an earlier one
+// on the same ingress bumps the count of the earlier event instead of creating
+// a new one, so that retries and periodic reconciles don't flood the API.
+type eventRecorder struct {
+	kubeClient *client.Client
+	source     api.EventSource
+
+	mu       sync.Mutex
+	recorded map[string]*api.Event
+}
+
+func newEventRecorder(kubeClient *client.Client) *eventRecorder {
+	host, _ := os.Hostname()
+	return &eventRecorder{
+		kubeClient: kubeClient,
+		source:     api.EventSource{Component: "citrix-ingress-controller", Host: host},
+		recorded:   make(map[string]*api.Event),
+	}
+}
+
+func (e *eventRecorder) Eventf(ing *extensions.Ingress, eventType string, reason string, format string, args ...interface{}) {
+	e.Event(ing, eventType, reason, fmt.Sprintf(format, args...))
+}
+
+func (e *eventRecorder) Event(ing *extensions.Ingress, eventType string, reason string, message string) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := unversioned.Now()
+	key := strings.Join([]string{string(ing.UID), eventType, reason, message}, "/")
+	if prev, ok := e.recorded[key]; ok {
+		event := *prev
+		event.Count++
+		event.LastTimestamp = now
+		updated, err := e.kubeClient.Events(ing.Namespace).Update(&event)
+		if err == nil {
+			e.recorded[key] = updated
+			return
+		}
+		// The event may have expired, record it afresh
+		delete(e.recorded, key)
+	}
+
+	event := &api.Event{
+		ObjectMeta: api.ObjectMeta{
+			Name:      fmt.Sprintf("%s.%x", ing.Name, now.UnixNano()),
+			Namespace: ing.Namespace,
+		},
+		InvolvedObject: api.ObjectReference{
+			Kind:            "Ingress",
+			APIVersion:      "extensions/v1beta1",
+			Namespace:       ing.Namespace,
+			Name:            ing.Name,
+			UID:             ing.UID,
+			ResourceVersion: ing.ResourceVersion,
+		},
+		Reason:         reason,
+		Message:        message,
+		Source:         e.source,
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+		Type:           eventType,
+	}
+	created, err := e.kubeClient.Events(ing.Namespace).Create(event)
+	if err != nil {
+		log.Printf("Failed to record event %s on ingress %s/%s: %v", reason, ing.Namespace, ing.Name, err)
+		return
+	}
+	if len(e.recorded) >= maxRecordedEvents {
+		e.recorded = make(map[string]*api.Event)
+	}
+	e.recorded[key] = created
+}
@@ -0,0 +1,52 @@
+/*
+Copyright 2016 Citrix Systems, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// hostExpression returns the NetScaler expression matching the host of
+// requests against the host of a rule, or "" for rules without host, which
+// match any host. A wildcard host such as *.example.com matches every host
+// ending in .example.com. Hosts are compared without the port of the request
+// and regardless of case.
+func hostExpression(host string) string {
+	host = strings.ToLower(host)
+	switch {
+	case host == "":
+		return ""
+	case strings.HasPrefix(host, "*."):
+		return fmt.Sprintf("HTTP.REQ.HOSTNAME.SERVER.SET_TEXT_MODE(IGNORECASE).ENDSWITH(%s)", quoteExpression(host[1:]))
+	default:
+		return fmt.Sprintf("HTTP.REQ.HOSTNAME.SERVER.SET_TEXT_MODE(IGNORECASE).EQ(%s)", quoteExpression(host))
+	}
+}
+
+// hostRank orders hosts by specificity: exact hosts, then wildcard hosts,
+// then rules without host.
+func hostRank(host string) int {
+	switch {
+	case host == "":
+		return 2
+	case strings.HasPrefix(host, "*."):
+		return 1
+	default:
+		return 0
+	}
+}
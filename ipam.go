@@ -0,0 +1,233 @@
+/*
+Copyright 2016 Citrix Systems, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+
+	"k8s.io/kubernetes/pkg/api"
+	apierrors "k8s.io/kubernetes/pkg/api/errors"
+	client "k8s.io/kubernetes/pkg/client/unversioned"
+)
+
+// vipAllocator hands out VIPs from a set of CIDR pools to ingresses without a
+// publicIP annotation. The allocations are kept in a ConfigMap mapping each
+// VIP to the key of its ingress, so they survive restarts and leader changes.
+// Only the leader allocates, it loads the ConfigMap when it takes over and
+// keeps its copy current from then on.
+type vipAllocator struct {
+	kubeClient *client.Client
+	namespace  string
+	name       string
+	pools      []*net.IPNet
+
+	mu          sync.Mutex
+	allocations map[string]string
+}
+
+// parseVipPools parses a comma separated list of CIDRs.
+func parseVipPools(cidrs string) ([]*net.IPNet, error) {
+	pools := []*net.IPNet{}
+	for _, cidr := range strings.Split(cidrs, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		_, pool, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		if pool.IP.To4() == nil {
+			return nil, fmt.Errorf("VIP pool %s is not an IPv4 range", cidr)
+		}
+		pools = append(pools, pool)
+	}
+	return pools, nil
+}
+
+func newVipAllocator(kubeClient *client.Client, namespace string, name string, pools []*net.IPNet) *vipAllocator {
+	return &vipAllocator{
+		kubeClient:  kubeClient,
+		namespace:   namespace,
+		name:        name,
+		pools:       pools,
+		allocations: make(map[string]string),
+	}
+}
+
+// Enabled reports whether any pool is configured.
+func (a *vipAllocator) Enabled() bool {
+	return a != nil && len(a.pools) > 0
+}
+
+// Load reads the allocations from the ConfigMap.
+func (a *vipAllocator) Load() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	cm, err := a.kubeClient.ConfigMaps(a.namespace).Get(a.name)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	a.allocations = make(map[string]string)
+	if err == nil {
+		for ip, key := range cm.Data {
+			a.allocations[ip] = key
+		}
+	}
+	log.Printf("Loaded %d VIP allocations from %s/%s", len(a.allocations), a.namespace, a.name)
+	return nil
+}
+
+// Lookup returns the VIP allocated to the ingress with the given key.
+func (a *vipAllocator) Lookup(key string) (string, bool) {
+	if a == nil {
+		return "", false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for ip, owner := range a.allocations {
+		if owner == key {
+			return ip, true
+		}
+	}
+	return "", false
+}
+
+// Owner returns the key of the ingress the VIP is allocated to.
+func (a *vipAllocator) Owner(ip string) (string, bool) {
+	if a == nil {
+		return "", false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	key, ok := a.allocations[ip]
+	return key, ok
+}
+
+// Keys returns the keys of the ingresses holding an allocation.
+func (a *vipAllocator) Keys() []string {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	keys := []string{}
+	for _, key := range a.allocations {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Allocate returns the VIP of the ingress with the given key, allocating a
+// free one if it has none yet. VIPs in inUse, such as those requested by
+// publicIP annotations, are never handed out.
+func (a *vipAllocator) Allocate(key string, inUse map[string]bool) (string, error) {
+	if ip, ok := a.Lookup(key); ok {
+		return ip, nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, pool := range a.pools {
+		for ip := firstHost(pool); ip != nil && pool.Contains(ip); ip = nextIP(ip) {
+			candidate := ip.String()
+			if _, ok := a.allocations[candidate]; ok || inUse[candidate] || isBroadcast(pool, ip) {
+				continue
+			}
+			a.allocations[candidate] = key
+			if err := a.save(); err != nil {
+				delete(a.allocations, candidate)
+				return "", err
+			}
+			log.Printf("Allocated VIP %s to ingress %s", candidate, key)
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("no free VIP left for ingress %s", key)
+}
+
+// Release frees the VIP of the ingress with the given key.
+func (a *vipAllocator) Release(key string) error {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for ip, owner := range a.allocations {
+		if owner != key {
+			continue
+		}
+		delete(a.allocations, ip)
+		if err := a.save(); err != nil {
+			a.allocations[ip] = owner
+			return err
+		}
+		log.Printf("Released VIP %s of ingress %s", ip, key)
+	}
+	return nil
+}
+
+// save writes the allocations to the ConfigMap, mu must be held.
+func (a *vipAllocator) save() error {
+	data := make(map[string]string)
+	for ip, key := range a.allocations {
+		data[ip] = key
+	}
+	cm, err := a.kubeClient.ConfigMaps(a.namespace).Get(a.name)
+	if apierrors.IsNotFound(err) {
+		_, err = a.kubeClient.ConfigMaps(a.namespace).Create(&api.ConfigMap{
+			ObjectMeta: api.ObjectMeta{Namespace: a.namespace, Name: a.name},
+			Data:       data,
+		})
+	} else if err == nil {
+		cm.Data = data
+		_, err = a.kubeClient.ConfigMaps(a.namespace).Update(cm)
+	}
+	if err != nil {
+		log.Printf("Failed to save VIP allocations to %s/%s: %v", a.namespace, a.name, err)
+	}
+	return err
+}
+
+// firstHost skips the network address of pools that have one.
+func firstHost(pool *net.IPNet) net.IP {
+	ip := pool.IP.To4()
+	if ones, bits := pool.Mask.Size(); bits-ones > 1 {
+		return nextIP(ip)
+	}
+	return ip
+}
+
+func isBroadcast(pool *net.IPNet, ip net.IP) bool {
+	ones, bits := pool.Mask.Size()
+	return bits-ones > 1 && !pool.Contains(nextIP(ip))
+}
+
+func nextIP(ip net.IP) net.IP {
+	next := make(net.IP, len(ip))
+	copy(next, ip)
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			return next
+		}
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2016 Citrix Systems, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	restclient "k8s.io/kubernetes/pkg/client/restclient"
+	client "k8s.io/kubernetes/pkg/client/unversioned"
+)
+
+// newTestVipAllocator returns an allocator keeping its ConfigMap in a fake
+// API server.
+func newTestVipAllocator(t *testing.T, cidrs string) (*vipAllocator, func()) {
+	var configMap []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch req.Method {
+		case "GET":
+			if configMap == nil {
+				w.WriteHeader(http.StatusNotFound)
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"kind": "Status", "apiVersion": "v1", "status": "Failure", "reason": "NotFound", "code": 404,
+				})
+				return
+			}
+			w.Write(configMap)
+		case "POST", "PUT":
+			configMap, _ = ioutil.ReadAll(req.Body)
+			w.WriteHeader(http.StatusCreated)
+			w.Write(configMap)
+		}
+	}))
+	kubeClient, err := client.New(&restclient.Config{Host: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pools, err := parseVipPools(cidrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newVipAllocator(kubeClient, "kube-system", "vips", pools), server.Close
+}
+
+func TestAllocateSkipsReservedAddresses(t *testing.T) {
+	for _, tc := range []struct {
+		pools string
+		inUse []string
+		want  []string
+	}{
+		// The network and broadcast addresses are skipped
+		{"10.0.0.0/30", nil, []string{"10.0.0.1", "10.0.0.2", ""}},
+		// Point to point pools have neither
+		{"10.0.0.0/31", nil, []string{"10.0.0.0", "10.0.0.1", ""}},
+		{"10.0.0.5/32", nil, []string{"10.0.0.5", ""}},
+		// publicIPs in use are skipped
+		{"10.0.0.0/29", []string{"10.0.0.1", "10.0.0.3"}, []string{"10.0.0.2", "10.0.0.4", "10.0.0.5", "10.0.0.6", ""}},
+		// The next pool is used once the first is exhausted
+		{"10.0.0.0/30,10.0.1.0/30", []string{"10.0.0.2"}, []string{"10.0.0.1", "10.0.1.1", "10.0.1.2", ""}},
+	} {
+		allocator, stop := newTestVipAllocator(t, tc.pools)
+		inUse := make(map[string]bool)
+		for _, ip := range tc.inUse {
+			inUse[ip] = true
+		}
+		for i, want := range tc.want {
+			key := "default/ing-" + strconv.Itoa(i)
+			got, err := allocator.Allocate(key, inUse)
+			if want == "" {
+				if err == nil {
+					t.Errorf("pools %s: allocation %d got %s, want none left", tc.pools, i, got)
+				}
+				continue
+			}
+			if err != nil || got != want {
+				t.Errorf("pools %s: allocation %d got %s (%v), want %s", tc.pools, i, got, err, want)
+			}
+			// Allocating again returns the same VIP
+			if again, _ := allocator.Allocate(key, inUse); again != got {
+				t.Errorf("pools %s: second allocation for %s got %s, want %s", tc.pools, key, again, got)
+			}
+		}
+		stop()
+	}
+}
+
+func TestIsBroadcast(t *testing.T) {
+	for _, tc := range []struct {
+		pool      string
+		ip        string
+		broadcast bool
+	}{
+		{"10.0.0.0/24", "10.0.0.255", true},
+		{"10.0.0.0/24", "10.0.0.254", false},
+		{"10.0.0.0/31", "10.0.0.1", false},
+		{"10.0.0.0/32", "10.0.0.0", false},
+	} {
+		_, pool, _ := net.ParseCIDR(tc.pool)
+		if got := isBroadcast(pool, net.ParseIP(tc.ip).To4()); got != tc.broadcast {
+			t.Errorf("isBroadcast(%s, %s) = %v, want %v", tc.pool, tc.ip, got, tc.broadcast)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+/*
+Copyright 2016 Citrix Systems, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"strings"
+
+	"github.com/chiradeep/go-nitro/config/lb"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+)
+
+// Annotations tuning the lb vservers of the backends of an ingress
+const (
+	persistenceAnnotation        = "netscaler/persistence"
+	persistenceTimeoutAnnotation = "netscaler/persistence-timeout"
+	persistenceCookieAnnotation  = "netscaler/persistence-cookie"
+	persistenceRuleAnnotation    = "netscaler/persistence-rule"
+	lbMethodAnnotation           = "netscaler/lb-method"
+	tokenRuleAnnotation          = "netscaler/token-rule"
+	clientTimeoutAnnotation      = "netscaler/client-timeout"
+	serverTimeoutAnnotation      = "netscaler/server-timeout"
+	backupVserverAnnotation      = "netscaler/backup-vserver"
+)
+
+// NetScaler defaults for HTTP load balancing, set explicitly so that removing
+// an annotation restores them.
+const (
+	defaultLbMethod      = "LEASTCONNECTION"
+	defaultClientTimeout = 180
+	defaultServerTimeout = 360
+)
+
+var persistenceTypes = map[string]bool{
+	"NONE":         true,
+	"COOKIEINSERT": true,
+	"SOURCEIP":     true,
+	"RULE":         true,
+	"SSLSESSION":   true,
+}
+
+var lbMethods = map[string]bool{
+	"ROUNDROBIN":        true,
+	"LEASTCONNECTION":   true,
+	"LEASTRESPONSETIME": true,
+	"TOKEN":             true,
+	"URLHASH":           true,
+	"CUSTOMLOAD":        true,
+}
+
+// lbVserverSettings are the settings of an lb vserver an ingress asks for
+// through its annotations. Zero values stand for the NetScaler defaults.
+type lbVserverSettings struct {
+	Persistence string
+	// PersistenceTimeout is in minutes
+	PersistenceTimeout int
+	CookieName         string
+	PersistenceRule    string
+	LbMethod           string
+	TokenRule          string
+	// Timeouts are in seconds, the server timeout applies to the services
+	// or servicegroup of the lb vserver
+	ClientTimeout int
+	ServerTimeout int
+	BackupVserver string
+}
+
+// ingressLbSettings returns the lb vserver settings of the annotations of the
+// ingress, or nil when it has none of them. Annotations with invalid values
+// are left out and reported.
+func ingressLbSettings(ing *extensions.Ingress) (*lbVserverSettings, []error) {
+	found := false
+	for _, annotation := range []string{persistenceAnnotation, lbMethodAnnotation, clientTimeoutAnnotation, serverTimeoutAnnotation, backupVserverAnnotation} {
+		if _, ok := ing.Annotations[annotation]; ok {
+			found = true
+		}
+	}
+	if !found {
+		return nil, nil
+	}
+
+	settings := &lbVserverSettings{Persistence: "NONE", LbMethod: defaultLbMethod}
+	errs := []error{}
+	if persistence, ok := ing.Annotations[persistenceAnnotation]; ok {
+		if persistenceTypes[strings.ToUpper(persistence)] {
+			settings.Persistence = strings.ToUpper(persistence)
+		} else {
+			errs = append(errs, invalidAnnotation(persistenceAnnotation, persistence))
+		}
+	}
+	if settings.Persistence == "RULE" {
+		settings.PersistenceRule = ing.Annotations[persistenceRuleAnnotation]
+		if settings.PersistenceRule == "" {
+			errs = append(errs, invalidAnnotation(persistenceRuleAnnotation, ""))
+			settings.Persistence = "NONE"
+		}
+	}
+	if settings.Persistence != "NONE" {
+		timeout, err := intAnnotation(ing, persistenceTimeoutAnnotation)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		settings.PersistenceTimeout = timeout
+	}
+	if settings.Persistence == "COOKIEINSERT" {
+		settings.CookieName = ing.Annotations[persistenceCookieAnnotation]
+	}
+
+	if method, ok := ing.Annotations[lbMethodAnnotation]; ok {
+		if lbMethods[strings.ToUpper(method)] {
+			settings.LbMethod = strings.ToUpper(method)
+		} else {
+			errs = append(errs, invalidAnnotation(lbMethodAnnotation, method))
+		}
+	}
+	if settings.LbMethod == "TOKEN" {
+		// The token rule and the persistence rule share the rule of the
+		// lb vserver
+		settings.TokenRule = ing.Annotations[tokenRuleAnnotation]
+		if settings.TokenRule == "" || (settings.PersistenceRule != "" && settings.PersistenceRule != settings.TokenRule) {
+			errs = append(errs, invalidAnnotation(tokenRuleAnnotation, settings.TokenRule))
+			settings.LbMethod = defaultLbMethod
+			settings.TokenRule = ""
+		}
+	}
+
+	for _, field := range []struct {
+		annotation string
+		value      *int
+	}{
+		{clientTimeoutAnnotation, &settings.ClientTimeout},
+		{serverTimeoutAnnotation, &settings.ServerTimeout},
+	} {
+		value, err := intAnnotation(ing, field.annotation)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		*field.value = value
+	}
+	settings.BackupVserver = ing.Annotations[backupVserverAnnotation]
+	return settings, errs
+}
+
+// serverTimeout returns the server timeout for the services of the lb
+// vserver.
+func (s *lbVserverSettings) serverTimeout() int {
+	if s == nil || s.ServerTimeout == 0 {
+		return defaultServerTimeout
+	}
+	return s.ServerTimeout
+}
+
+// lbVserver returns the settings as NITRO takes them, nil settings are the
+// defaults. An empty cookie name, rule or backup vserver is unset by
+// AddLbVServer.
+func (s *lbVserverSettings) lbVserver() lb.Lbvserver {
+	if s == nil {
+		s = &lbVserverSettings{Persistence: "NONE", LbMethod: defaultLbMethod}
+	}
+	vserver := lb.Lbvserver{
+		Persistencetype: s.Persistence,
+		Cookiename:      s.CookieName,
+		Rule:            s.PersistenceRule,
+		Lbmethod:        s.LbMethod,
+		Clttimeout:      defaultClientTimeout,
+		Backupvserver:   s.BackupVserver,
+	}
+	if s.TokenRule != "" {
+		vserver.Rule = s.TokenRule
+	}
+	if s.ClientTimeout > 0 {
+		vserver.Clttimeout = s.ClientTimeout
+	}
+	if s.Persistence != "NONE" {
+		// The NetScaler default of 2 minutes, so that removing the
+		// timeout annotation restores it
+		vserver.Timeout = 2
+		if s.PersistenceTimeout > 0 {
+			vserver.Timeout = s.PersistenceTimeout
+		}
+	}
+	return vserver
+}
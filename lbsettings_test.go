@@ -0,0 +1,157 @@
+/*
+Copyright 2016 Citrix Systems, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+)
+
+func TestIngressLbSettings(t *testing.T) {
+	defaults := func(s lbVserverSettings) *lbVserverSettings {
+		if s.Persistence == "" {
+			s.Persistence = "NONE"
+		}
+		if s.LbMethod == "" {
+			s.LbMethod = defaultLbMethod
+		}
+		return &s
+	}
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        *lbVserverSettings
+		errs        int
+	}{
+		{name: "no annotations", annotations: map[string]string{}, want: nil},
+		{
+			name:        "only dependent annotations",
+			annotations: map[string]string{persistenceTimeoutAnnotation: "10", tokenRuleAnnotation: "HTTP.REQ.URL"},
+			want:        nil,
+		},
+		{
+			name: "cookie persistence",
+			annotations: map[string]string{
+				persistenceAnnotation:        "cookieinsert",
+				persistenceTimeoutAnnotation: "10",
+				persistenceCookieAnnotation:  "session",
+			},
+			want: defaults(lbVserverSettings{Persistence: "COOKIEINSERT", PersistenceTimeout: 10, CookieName: "session"}),
+		},
+		{
+			name:        "cookie name without cookie persistence",
+			annotations: map[string]string{persistenceAnnotation: "SOURCEIP", persistenceCookieAnnotation: "session"},
+			want:        defaults(lbVserverSettings{Persistence: "SOURCEIP"}),
+		},
+		{
+			name:        "unknown persistence",
+			annotations: map[string]string{persistenceAnnotation: "STICKY"},
+			want:        defaults(lbVserverSettings{}),
+			errs:        1,
+		},
+		{
+			name:        "invalid persistence timeout",
+			annotations: map[string]string{persistenceAnnotation: "SOURCEIP", persistenceTimeoutAnnotation: "ten"},
+			want:        defaults(lbVserverSettings{Persistence: "SOURCEIP"}),
+			errs:        1,
+		},
+		{
+			name:        "rule persistence",
+			annotations: map[string]string{persistenceAnnotation: "RULE", persistenceRuleAnnotation: "HTTP.REQ.HEADER(\"X-User\")"},
+			want:        defaults(lbVserverSettings{Persistence: "RULE", PersistenceRule: "HTTP.REQ.HEADER(\"X-User\")"}),
+		},
+		{
+			name:        "rule persistence without rule",
+			annotations: map[string]string{persistenceAnnotation: "RULE"},
+			want:        defaults(lbVserverSettings{}),
+			errs:        1,
+		},
+		{
+			name:        "lb method",
+			annotations: map[string]string{lbMethodAnnotation: "roundrobin"},
+			want:        defaults(lbVserverSettings{LbMethod: "ROUNDROBIN"}),
+		},
+		{
+			name:        "unknown lb method",
+			annotations: map[string]string{lbMethodAnnotation: "RANDOM"},
+			want:        defaults(lbVserverSettings{}),
+			errs:        1,
+		},
+		{
+			name:        "token lb method",
+			annotations: map[string]string{lbMethodAnnotation: "TOKEN", tokenRuleAnnotation: "HTTP.REQ.URL"},
+			want:        defaults(lbVserverSettings{LbMethod: "TOKEN", TokenRule: "HTTP.REQ.URL"}),
+		},
+		{
+			name:        "token lb method without rule",
+			annotations: map[string]string{lbMethodAnnotation: "TOKEN"},
+			want:        defaults(lbVserverSettings{}),
+			errs:        1,
+		},
+		{
+			name: "token rule sharing the persistence rule",
+			annotations: map[string]string{
+				persistenceAnnotation:     "RULE",
+				persistenceRuleAnnotation: "HTTP.REQ.URL",
+				lbMethodAnnotation:        "TOKEN",
+				tokenRuleAnnotation:       "HTTP.REQ.URL",
+			},
+			want: defaults(lbVserverSettings{Persistence: "RULE", PersistenceRule: "HTTP.REQ.URL", LbMethod: "TOKEN", TokenRule: "HTTP.REQ.URL"}),
+		},
+		{
+			name: "token rule conflicting with the persistence rule",
+			annotations: map[string]string{
+				persistenceAnnotation:     "RULE",
+				persistenceRuleAnnotation: "HTTP.REQ.URL",
+				lbMethodAnnotation:        "TOKEN",
+				tokenRuleAnnotation:       "CLIENT.IP.SRC",
+			},
+			want: defaults(lbVserverSettings{Persistence: "RULE", PersistenceRule: "HTTP.REQ.URL"}),
+			errs: 1,
+		},
+		{
+			name:        "timeouts",
+			annotations: map[string]string{clientTimeoutAnnotation: "60", serverTimeoutAnnotation: "120"},
+			want:        defaults(lbVserverSettings{ClientTimeout: 60, ServerTimeout: 120}),
+		},
+		{
+			name:        "invalid timeouts",
+			annotations: map[string]string{clientTimeoutAnnotation: "0", serverTimeoutAnnotation: "-5"},
+			want:        defaults(lbVserverSettings{}),
+			errs:        2,
+		},
+		{
+			name:        "backup vserver",
+			annotations: map[string]string{backupVserverAnnotation: "lb_default_sorry_80"},
+			want:        defaults(lbVserverSettings{BackupVserver: "lb_default_sorry_80"}),
+		},
+	}
+
+	for _, tt := range tests {
+		ing := &extensions.Ingress{ObjectMeta: api.ObjectMeta{Annotations: tt.annotations}}
+		got, errs := ingressLbSettings(ing)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+		if len(errs) != tt.errs {
+			t.Errorf("%s: got errors %v, want %d", tt.name, errs, tt.errs)
+		}
+	}
+}
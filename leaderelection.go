@@ -0,0 +1,161 @@
+/*
+Copyright 2016 Citrix Systems, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"reflect"
+	"time"
+
+	"k8s.io/kubernetes/pkg/api"
+	apierrors "k8s.io/kubernetes/pkg/api/errors"
+	client "k8s.io/kubernetes/pkg/client/unversioned"
+	"k8s.io/kubernetes/pkg/util/wait"
+)
+
+// leaderAnnotation holds the lock record on the Endpoints object, the same
+// annotation the Kubernetes control plane components use.
+const leaderAnnotation = "control-plane.alpha.kubernetes.io/leader"
+
+type leaderElectionRecord struct {
+	HolderIdentity       string    `json:"holderIdentity"`
+	LeaseDurationSeconds int       `json:"leaseDurationSeconds"`
+	AcquireTime          time.Time `json:"acquireTime"`
+	RenewTime            time.Time `json:"renewTime"`
+	LeaderTransitions    int       `json:"leaderTransitions"`
+}
+
+// leaderElector holds a lease in an annotation of an Endpoints object. Updates
+// of the object are guarded by its resource version, so only one replica can
+// take over an expired lease. The lease is considered expired when its record
+// has not changed for leaseDuration, measured with the local clock so that
+// clock skew between replicas does not matter.
+type leaderElector struct {
+	kubeClient *client.Client
+	namespace  string
+	name       string
+	identity   string
+
+	leaseDuration time.Duration
+	renewDeadline time.Duration
+	retryPeriod   time.Duration
+
+	observedRecord leaderElectionRecord
+	observedTime   time.Time
+}
+
+func newLeaderElector(kubeClient *client.Client, namespace string, name string, identity string) *leaderElector {
+	return &leaderElector{
+		kubeClient:    kubeClient,
+		namespace:     namespace,
+		name:          name,
+		identity:      identity,
+		leaseDuration: 15 * time.Second,
+		renewDeadline: 10 * time.Second,
+		retryPeriod:   2 * time.Second,
+	}
+}
+
+// Run blocks until the lease is acquired and then calls onStartedLeading. It
+// returns once the lease could not be renewed within the renew deadline, the
+// caller has to stop acting as the leader at that point.
+func (le *leaderElector) Run(onStartedLeading func()) {
+	log.Printf("Waiting to acquire the leader lease %s/%s as %s", le.namespace, le.name, le.identity)
+	wait.PollInfinite(le.retryPeriod, func() (bool, error) {
+		return le.tryAcquireOrRenew(), nil
+	})
+	log.Printf("Acquired the leader lease %s/%s", le.namespace, le.name)
+	go onStartedLeading()
+
+	for {
+		err := wait.Poll(le.retryPeriod, le.renewDeadline, func() (bool, error) {
+			return le.tryAcquireOrRenew(), nil
+		})
+		if err != nil {
+			log.Printf("Failed to renew the leader lease %s/%s: %v", le.namespace, le.name, err)
+			return
+		}
+		time.Sleep(le.retryPeriod)
+	}
+}
+
+func (le *leaderElector) tryAcquireOrRenew() bool {
+	now := time.Now()
+	record := leaderElectionRecord{
+		HolderIdentity:       le.identity,
+		LeaseDurationSeconds: int(le.leaseDuration / time.Second),
+		AcquireTime:          now,
+		RenewTime:            now,
+	}
+
+	ep, err := le.kubeClient.Endpoints(le.namespace).Get(le.name)
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			log.Printf("Failed to retrieve the leader lease %s/%s: %v", le.namespace, le.name, err)
+			return false
+		}
+		raw, _ := json.Marshal(record)
+		_, err = le.kubeClient.Endpoints(le.namespace).Create(&api.Endpoints{
+			ObjectMeta: api.ObjectMeta{
+				Namespace:   le.namespace,
+				Name:        le.name,
+				Annotations: map[string]string{leaderAnnotation: string(raw)},
+			},
+		})
+		if err != nil {
+			return false
+		}
+		le.observedRecord = record
+		le.observedTime = now
+		return true
+	}
+
+	if ep.Annotations == nil {
+		ep.Annotations = make(map[string]string)
+	}
+	var current leaderElectionRecord
+	if raw, ok := ep.Annotations[leaderAnnotation]; ok {
+		if err := json.Unmarshal([]byte(raw), &current); err != nil {
+			log.Printf("Ignoring malformed leader lease %s/%s: %v", le.namespace, le.name, err)
+		}
+		if !reflect.DeepEqual(current, le.observedRecord) {
+			le.observedRecord = current
+			le.observedTime = now
+		}
+	}
+	if current.HolderIdentity != "" && current.HolderIdentity != le.identity &&
+		le.observedTime.Add(le.leaseDuration).After(now) {
+		return false
+	}
+
+	if current.HolderIdentity == le.identity {
+		record.AcquireTime = current.AcquireTime
+		record.LeaderTransitions = current.LeaderTransitions
+	} else {
+		record.LeaderTransitions = current.LeaderTransitions + 1
+	}
+	raw, _ := json.Marshal(record)
+	ep.Annotations[leaderAnnotation] = string(raw)
+	if _, err := le.kubeClient.Endpoints(le.namespace).Update(ep); err != nil {
+		log.Printf("Failed to update the leader lease %s/%s: %v", le.namespace, le.name, err)
+		return false
+	}
+	le.observedRecord = record
+	le.observedTime = now
+	return true
+}
@@ -0,0 +1,165 @@
+/*
+Copyright 2016 Citrix Systems, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chiradeep/go-nitro/netscaler"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsNamespace = "citrix_ingress"
+
+var (
+	nitroRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "nitro_requests_total",
+			Help:      "NITRO requests sent to the NetScaler, by resource type and verb.",
+		},
+		[]string{"resource", "verb"},
+	)
+	nitroRequestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "nitro_request_duration_seconds",
+			Help:      "Latency of NITRO requests, by resource type and verb.",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"resource", "verb"},
+	)
+	nitroErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "nitro_errors_total",
+			Help:      "Failed NITRO requests, by NITRO errorcode. Failures without a NITRO response have errorcode none.",
+		},
+		[]string{"errorcode"},
+	)
+	reconcileDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "reconcile_duration_seconds",
+			Help:      "Duration of ingress syncs and garbage collection runs.",
+			Buckets:   prometheus.ExponentialBuckets(0.01, 2, 14),
+		},
+		[]string{"operation", "result"},
+	)
+	managedIngresses = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "managed_ingresses",
+		Help:      "Ingresses configured on the NetScaler.",
+	})
+	managedEndpoints = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "managed_endpoints",
+		Help:      "Endpoints configured as NetScaler services.",
+	})
+	lastSuccessfulSync = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "last_successful_sync_timestamp_seconds",
+		Help:      "Unix time of the last ingress sync or garbage collection run that succeeded.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(nitroRequests)
+	prometheus.MustRegister(nitroRequestDuration)
+	prometheus.MustRegister(nitroErrors)
+	prometheus.MustRegister(reconcileDuration)
+	prometheus.MustRegister(managedIngresses)
+	prometheus.MustRegister(managedEndpoints)
+	prometheus.MustRegister(lastSuccessfulSync)
+	netscaler.RequestObserver = observeNitroRequest
+}
+
+// registerQueueDepth exports the number of ingresses waiting to be synced.
+func registerQueueDepth(queue *rateLimitedQueue) {
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "queue_depth",
+		Help:      "Ingresses waiting to be synced.",
+	}, func() float64 {
+		return float64(queue.Len())
+	}))
+}
+
+// observeReconcile records the duration and outcome of a sync or garbage
+// collection run that started at start.
+func observeReconcile(operation string, start time.Time, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	} else {
+		lastSuccessfulSync.Set(float64(time.Now().Unix()))
+	}
+	reconcileDuration.WithLabelValues(operation, result).Observe(time.Since(start).Seconds())
+}
+
+func observeNitroRequest(method string, url string, duration time.Duration, err error) {
+	resource, verb := nitroRequestLabels(method, url)
+	nitroRequests.WithLabelValues(resource, verb).Inc()
+	nitroRequestDuration.WithLabelValues(resource, verb).Observe(duration.Seconds())
+	if err == nil {
+		return
+	}
+	errorcode := "none"
+	if nitroErr, ok := ParseNitroError(err); ok {
+		errorcode = strconv.Itoa(nitroErr.Errorcode)
+	}
+	nitroErrors.WithLabelValues(errorcode).Inc()
+}
+
+// nitroRequestLabels derives the resource type and verb of a NITRO request
+// from its method and URL, e.g. DELETE .../config/lbvserver_service_binding/x
+// is an unbind of lbvserver_service_binding.
+func nitroRequestLabels(method string, url string) (string, string) {
+	path := url
+	if i := strings.Index(path, "/nitro/v1/"); i >= 0 {
+		path = path[i+len("/nitro/v1/"):]
+	}
+	query := ""
+	if i := strings.Index(path, "?"); i >= 0 {
+		path, query = path[:i], path[i+1:]
+	}
+	api := ""
+	if i := strings.Index(path, "/"); i >= 0 {
+		api, path = path[:i], path[i+1:]
+	}
+	resource := strings.Split(strings.TrimLeft(path, "/"), "/")[0]
+	binding := strings.HasSuffix(resource, "_binding")
+
+	verb := strings.ToLower(method)
+	switch {
+	case api == "stat":
+		verb = "stat"
+	case method == "POST" && strings.HasPrefix(query, "action="):
+		verb = strings.TrimPrefix(query, "action=")
+	case method == "POST" && binding:
+		verb = "bind"
+	case method == "POST":
+		verb = "add"
+	case method == "PUT":
+		verb = "update"
+	case method == "DELETE" && binding:
+		verb = "unbind"
+	}
+	return resource, verb
+}
@@ -0,0 +1,346 @@
+/*
+Copyright 2016 Citrix Systems, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/chiradeep/go-nitro/config/lb"
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	utilerrors "k8s.io/kubernetes/pkg/util/errors"
+	"k8s.io/kubernetes/pkg/util/intstr"
+)
+
+// Annotations choosing the health check of the backends of an ingress. Without
+// them the probes of the pods are used.
+const (
+	monitorAnnotation         = "netscaler/monitor"
+	monitorPathAnnotation     = "netscaler/monitor-path"
+	monitorPortAnnotation     = "netscaler/monitor-port"
+	monitorRespcodeAnnotation = "netscaler/monitor-respcode"
+	monitorRecvAnnotation     = "netscaler/monitor-recv"
+	monitorIntervalAnnotation = "netscaler/monitor-interval"
+	monitorTimeoutAnnotation  = "netscaler/monitor-timeout"
+	monitorRetriesAnnotation  = "netscaler/monitor-retries"
+)
+
+// probeRespcode are the status codes kubelet accepts from HTTP probes.
+const probeRespcode = "200-399"
+
+// monitorConfig is an lbmonitor probing services. Port is left 0 to probe the
+// port of the service itself, the timing fields are left 0 for the NetScaler
+// defaults.
+type monitorConfig struct {
+	Name           string
+	Type           string
+	Request        string
+	Respcode       string
+	Recv           string
+	Headers        string
+	Port           int
+	Secure         bool
+	Interval       int
+	Timeout        int
+	Retries        int
+	SuccessRetries int
+}
+
+// settings describes everything but the name, monitors are named after it.
+func (m *monitorConfig) settings() string {
+	settings := *m
+	settings.Name = ""
+	return fmt.Sprintf("%+v", settings)
+}
+
+// normalize fills in the timing the NetScaler insists on, the response
+// timeout has to be shorter than the interval.
+func (m *monitorConfig) normalize() {
+	if m.Interval > 0 && m.Timeout == 0 {
+		m.Timeout = 2
+	}
+	if m.Timeout > 0 && m.Interval == 0 {
+		m.Interval = 5
+	}
+	if m.Timeout > 0 && m.Timeout >= m.Interval {
+		m.Interval = m.Timeout + 1
+	}
+}
+
+func (m *monitorConfig) lbMonitor() *lb.Lbmonitor {
+	monitor := &lb.Lbmonitor{
+		Monitorname:    m.Name,
+		Type:           m.Type,
+		Destport:       m.Port,
+		Interval:       m.Interval,
+		Resptimeout:    m.Timeout,
+		Retries:        m.Retries,
+		Successretries: m.SuccessRetries,
+	}
+	switch m.Type {
+	case "HTTP":
+		monitor.Httprequest = m.Request
+		monitor.Customheaders = m.Headers
+		if m.Respcode != "" {
+			monitor.Respcode = []string{m.Respcode}
+		}
+	case "HTTP-ECV":
+		monitor.Send = m.Request
+		monitor.Recv = m.Recv
+		monitor.Customheaders = m.Headers
+	}
+	if m.Secure {
+		monitor.Secure = "YES"
+	}
+	return monitor
+}
+
+// intAnnotation parses an annotation holding a positive number, an absent
+// annotation is 0.
+func intAnnotation(ing *extensions.Ingress, name string) (int, error) {
+	value, ok := ing.Annotations[name]
+	if !ok {
+		return 0, nil
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil || i <= 0 {
+		return 0, invalidAnnotation(name, value)
+	}
+	return i, nil
+}
+
+// ingressMonitor returns the monitor the annotations of the ingress ask for.
+// explicit is false when the ingress leaves the choice to the probes of the
+// pods, a nil monitor that is explicit turns health checks off.
+func ingressMonitor(ing *extensions.Ingress) (monitor *monitorConfig, explicit bool, err error) {
+	monitorType, ok := ing.Annotations[monitorAnnotation]
+	if !ok {
+		return nil, false, nil
+	}
+	m := &monitorConfig{Type: strings.ToUpper(monitorType)}
+	switch m.Type {
+	case "NONE":
+		return nil, true, nil
+	case "HTTP", "HTTP-ECV", "TCP":
+	default:
+		return nil, false, invalidAnnotation(monitorAnnotation, monitorType)
+	}
+
+	path, ok := ing.Annotations[monitorPathAnnotation]
+	if !ok {
+		path = "/"
+	} else if !strings.HasPrefix(path, "/") {
+		return nil, false, invalidAnnotation(monitorPathAnnotation, path)
+	}
+	if m.Type != "TCP" {
+		m.Request = "GET " + path
+	}
+	if m.Type == "HTTP" {
+		m.Respcode = probeRespcode
+		if respcode, ok := ing.Annotations[monitorRespcodeAnnotation]; ok {
+			m.Respcode = respcode
+		}
+	}
+	if m.Type == "HTTP-ECV" {
+		m.Recv = ing.Annotations[monitorRecvAnnotation]
+	}
+	for _, field := range []struct {
+		annotation string
+		value      *int
+	}{
+		{monitorPortAnnotation, &m.Port},
+		{monitorIntervalAnnotation, &m.Interval},
+		{monitorTimeoutAnnotation, &m.Timeout},
+		{monitorRetriesAnnotation, &m.Retries},
+	} {
+		if *field.value, err = intAnnotation(ing, field.annotation); err != nil {
+			return nil, false, err
+		}
+	}
+	if m.Port > 65535 {
+		return nil, false, invalidAnnotation(monitorPortAnnotation, ing.Annotations[monitorPortAnnotation])
+	}
+	m.normalize()
+	return m, true, nil
+}
+
+// probeMonitor derives a monitor from the readiness probe, or else the
+// liveness probe, of the container serving the endpoint. Exec probes have no
+// NetScaler counterpart and are not translated.
+func (r *reconciler) probeMonitor(ep endpointAddress) *monitorConfig {
+	if ep.Pod == "" {
+		return nil
+	}
+	obj, exists, err := r.podLister.GetByKey(ep.Pod)
+	if err != nil || !exists {
+		return nil
+	}
+	pod := obj.(*api.Pod)
+	container := servingContainer(pod, ep.Port)
+	if container == nil {
+		return nil
+	}
+	probe := container.ReadinessProbe
+	if probe == nil {
+		probe = container.LivenessProbe
+	}
+	if probe == nil {
+		return nil
+	}
+
+	m := &monitorConfig{
+		Interval:       probe.PeriodSeconds,
+		Timeout:        probe.TimeoutSeconds,
+		Retries:        probe.FailureThreshold,
+		SuccessRetries: probe.SuccessThreshold,
+	}
+	var port intstr.IntOrString
+	switch {
+	case probe.HTTPGet != nil:
+		m.Type = "HTTP"
+		path := probe.HTTPGet.Path
+		if path == "" {
+			path = "/"
+		}
+		m.Request = "GET " + path
+		m.Respcode = probeRespcode
+		m.Secure = probe.HTTPGet.Scheme == api.URISchemeHTTPS
+		for _, header := range probe.HTTPGet.HTTPHeaders {
+			m.Headers += header.Name + ": " + header.Value + "\r\n"
+		}
+		port = probe.HTTPGet.Port
+	case probe.TCPSocket != nil:
+		m.Type = "TCP"
+		port = probe.TCPSocket.Port
+	default:
+		return nil
+	}
+	probePort, ok := containerPort(container, port)
+	if !ok {
+		return nil
+	}
+	if probePort != ep.Port {
+		m.Port = probePort
+	}
+	m.normalize()
+	return m
+}
+
+// servingContainer returns the container of the pod listening on the port.
+// Declaring ports is optional, a pod with a single container is assumed to
+// serve on it.
+func servingContainer(pod *api.Pod, port int) *api.Container {
+	for i := range pod.Spec.Containers {
+		container := &pod.Spec.Containers[i]
+		for _, p := range container.Ports {
+			if p.ContainerPort == port {
+				return container
+			}
+		}
+	}
+	if len(pod.Spec.Containers) == 1 {
+		return &pod.Spec.Containers[0]
+	}
+	return nil
+}
+
+// containerPort resolves the number or name of a port of the container.
+func containerPort(container *api.Container, port intstr.IntOrString) (int, bool) {
+	if port.Type == intstr.Int {
+		return port.IntValue(), true
+	}
+	for _, p := range container.Ports {
+		if p.Name == port.StrVal {
+			return p.ContainerPort, true
+		}
+	}
+	return 0, false
+}
+
+// unbindMonitor unbinds the monitor from a service of ours. A monitor of ours
+// no longer wanted is removed once it is unbound from the last service or
+// servicegroup, monitors that merely have no bindings are never removed since
+// they may be someone else's.
+func (r *reconciler) unbindMonitor(cfg *nsConfig, sname string, monitorName string) error {
+	if err := UnbindMonitor(sname, monitorName); err != nil {
+		return err
+	}
+	return r.deleteUnusedMonitor(cfg, monitorName)
+}
+
+// unbindServicegroupMonitor is unbindMonitor for a servicegroup of ours.
+func (r *reconciler) unbindServicegroupMonitor(cfg *nsConfig, sgName string, monitorName string) error {
+	if err := UnbindServicegroupMonitor(sgName, monitorName); err != nil {
+		return err
+	}
+	return r.deleteUnusedMonitor(cfg, monitorName)
+}
+
+// releaseMonitors unbinds the monitors of a service of ours that is about to
+// be removed, which would otherwise drop the bindings without removing the
+// monitors.
+func (r *reconciler) releaseMonitors(cfg *nsConfig, sname string) error {
+	monitors, err := ListBoundMonitorsForService(sname)
+	if err != nil {
+		return err
+	}
+	errs := []error{}
+	for _, monitorName := range monitors {
+		if err := r.unbindMonitor(cfg, sname, monitorName); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// releaseServicegroupMonitors is releaseMonitors for a servicegroup of ours.
+func (r *reconciler) releaseServicegroupMonitors(cfg *nsConfig, sgName string) error {
+	monitors, err := ListBoundMonitorsForServicegroup(sgName)
+	if err != nil {
+		return err
+	}
+	errs := []error{}
+	for _, monitorName := range monitors {
+		if err := r.unbindServicegroupMonitor(cfg, sgName, monitorName); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+func (r *reconciler) deleteUnusedMonitor(cfg *nsConfig, monitorName string) error {
+	if _, ok := cfg.Monitors[monitorName]; ok || !strings.HasPrefix(monitorName, "mon_") {
+		return nil
+	}
+	services, err := ListBoundServicesForMonitor(monitorName)
+	if err != nil || len(services) > 0 {
+		return err
+	}
+	servicegroups, err := ListBoundServicegroupsForMonitor(monitorName)
+	if err != nil || len(servicegroups) > 0 {
+		return err
+	}
+	monitorType, err := GetMonitorType(monitorName)
+	if err != nil {
+		return nil
+	}
+	log.Printf("Removing stale monitor %s", monitorName)
+	return DeleteMonitor(monitorName, monitorType)
+}
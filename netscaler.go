@@ -17,6 +17,10 @@ limitations under the License.
 package main
 
 import (
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/chiradeep/go-nitro/config/basic"
@@ -24,298 +28,1309 @@ import (
 	"github.com/chiradeep/go-nitro/config/lb"
 	"github.com/chiradeep/go-nitro/netscaler"
 	"log"
-	"sort"
+	"os"
+	"path"
 	"strconv"
 	"strings"
 )
 
-func GenerateLbName(namespace string, host string) string {
-	lbName := "lb_" + strings.Replace(host, ".", "_", -1)
+// GenerateLbName names the lb vserver fronting one port of a kubernetes
+// service. servicePort is the number of the service port, or the port as given
+// in the ingress backend when the service is unknown.
+func GenerateLbName(namespace string, serviceName string, servicePort string) string {
+	lbName := "lb_" + namespace + "_" + serviceName + "_" + servicePort
 	return lbName
 }
 
+// GenerateServicegroupName names the servicegroup holding the endpoints of
+// one port of a kubernetes service.
+func GenerateServicegroupName(namespace string, serviceName string, servicePort string) string {
+	sgName := "sg_" + namespace + "_" + serviceName + "_" + servicePort
+	return sgName
+}
+
 func GenerateCsVserverName(namespace string, ingressName string) string {
 	csv := "cs_" + namespace + "_" + ingressName
 	return csv
 }
 
-func GeneratePolicyName(namespace string, host string, path string) string {
+// GeneratePolicyName names the content switching policy for the host and path
+// of a rule of an ingress. Policies are never shared between ingresses, which
+// may route the same host and path differently.
+func GeneratePolicyName(namespace string, ingressName string, host string, path string) string {
+	return csRuleName(namespace, ingressName, host, path) + "_policy"
+}
+
+// GenerateActionName names the content switching action of the policy for the
+// host and path of a rule of an ingress.
+func GenerateActionName(namespace string, ingressName string, host string, path string) string {
+	return csRuleName(namespace, ingressName, host, path) + "_action"
+}
+
+// maxNameLength is the longest object name NetScaler accepts.
+const maxNameLength = 127
+
+// csRuleName names the policy and action of a rule, without their suffix. The
+// path part is a digest of the path when the path has characters names can't
+// hold, such as those of regular expressions, when it has underscores that
+// could not be told apart from its slashes, or when the name would get too
+// long. Names still too long are digests as a whole.
+func csRuleName(namespace string, ingressName string, host string, path string) string {
+	prefix := namespace + "_" + ingressName + "_" + policyNameHost(host) + "-"
 	path_ := path
 	if path == "" {
 		path_ = "nilpath"
 	}
 	path_ = strings.Replace(path_, "/", "_", -1)
-	host = strings.Replace(host, ".", "_", -1)
+	// The longest suffix is "_policy"
+	maxLength := maxNameLength - len("_policy")
+	if !isValidName(path_) || strings.Contains(path, "_") || len(prefix+path_) > maxLength {
+		hash := sha1.Sum([]byte(path))
+		path_ = "p_" + hex.EncodeToString(hash[:])[:12]
+	}
+	if len(prefix+path_) > maxLength {
+		hash := sha1.Sum([]byte(namespace + "/" + ingressName + "/" + host + "/" + path))
+		return "rule_" + hex.EncodeToString(hash[:])[:24]
+	}
+	return prefix + path_
+}
 
-	policyName := host + "-" + path_ + "_policy"
-	return policyName
+// isValidName reports whether the string only has characters NetScaler allows
+// in object names. # and space are allowed as well, but go-nitro puts names
+// into URLs without escaping them.
+func isValidName(name string) bool {
+	for _, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.ContainsRune("_.:@=-", c):
+		default:
+			return false
+		}
+	}
+	return true
 }
 
-func GenerateActionName(namespace string, host string, path string) string {
-	path_ := path
-	if path == "" {
-		path_ = "nilpath"
+// policyNameHost turns the host of a rule into a part of a name. The * of
+// wildcard hosts is not allowed in names, it becomes _wildcard, which no
+// other host can be mistaken for since hosts don't contain underscores.
+func policyNameHost(host string) string {
+	host = strings.Replace(strings.ToLower(host), "*", "_wildcard", -1)
+	return strings.Replace(host, ".", "_", -1)
+}
+
+// GenerateSslCsVserverName names the content vserver terminating TLS for an
+// ingress.
+func GenerateSslCsVserverName(namespace string, ingressName string) string {
+	csv := "cs_" + namespace + "_" + ingressName + "_ssl"
+	return csv
+}
+
+// GenerateCertKeyName names the certkey for a TLS secret. Certkeys have no
+// comment to carry the ownership marker, so their names carry the cluster ID.
+func GenerateCertKeyName(namespace string, secretName string) string {
+	certkey := certKeyPrefix() + namespace + "_" + secretName
+	return certkey
+}
+
+func certKeyPrefix() string {
+	return "ck_" + clusterID + "_"
+}
+
+// IsOwnedCertKey reports whether the certkey name was generated by this
+// cluster. Namespaces and secret names contain no underscore, which tells
+// cluster IDs that are prefixes of one another apart.
+func IsOwnedCertKey(certkeyName string) bool {
+	rest := strings.TrimPrefix(certkeyName, certKeyPrefix())
+	return rest != certkeyName && strings.Count(rest, "_") == 1
+}
+
+func GenerateServiceName(namespace string, serviceName string, ip string, port int) string {
+	sname := "svc_" + namespace + "_" + serviceName + "_" + strings.Replace(ip, ".", "_", -1) + "_" + strconv.Itoa(port)
+	return sname
+}
+
+// GenerateMonitorName names a monitor after the cluster, the backend it probes
+// and its settings, so that monitors never need to be updated in place and
+// are not shared between clusters. Monitor names are limited to 31
+// characters, hence the digest.
+func GenerateMonitorName(namespace string, serviceName string, settings string) string {
+	hash := sha1.Sum([]byte(clusterID + "/" + namespace + "/" + serviceName + "/" + settings))
+	return "mon_" + hex.EncodeToString(hash[:])[:12]
+}
+
+// GeneratePolicyRule returns the rule of the content switching policy for the
+// host and path of an ingress rule, match is one of the path match types.
+func GeneratePolicyRule(host string, path string, match string) string {
+	conditions := []string{}
+	for _, expr := range []string{hostExpression(host), pathExpression(path, match)} {
+		if expr != "" {
+			conditions = append(conditions, expr)
+		}
 	}
-	path_ = strings.Replace(path_, "/", "_", -1)
-	host = strings.Replace(host, ".", "_", -1)
-	actionName := host + "-" + path_ + "_action"
-	return actionName
+	if len(conditions) == 0 {
+		return "true"
+	}
+	return strings.Join(conditions, " && ")
 }
 
-func DeleteService(sname string) {
-	client, _ := netscaler.NewNitroClientFromEnv()
-	err := client.DeleteResource(netscaler.Service.Type(), sname)
-	if err != nil {
-		log.Println(fmt.Sprintf("Failed to delete service %s err=%s", sname, err))
+// Every object the controller creates carries an ownership marker in its
+// comment, naming the cluster (CLUSTER_ID) and the ingress the object was
+// created for. Objects carrying the marker of another cluster are never
+// modified, and only objects carrying our own marker are garbage collected.
+// Unmarked objects with a name the controller would generate are adopted.
+const ownerMarkerPrefix = "kube-ingress"
+
+var clusterID = getClusterID()
+
+func getClusterID() string {
+	id := os.Getenv("CLUSTER_ID")
+	if id == "" {
+		id = "default"
 	}
+	return id
+}
+
+// OwnerComment returns the ownership marker for objects created for the
+// ingress with the given namespace/name key.
+func OwnerComment(ingressKey string) string {
+	return ownerMarkerPrefix + ":" + clusterID + ":" + ingressKey
+}
+
+// IsOwned reports whether the comment is an ownership marker of this cluster.
+// Ingress keys hold no colon, so that cluster a does not claim the objects of
+// cluster a:b.
+func IsOwned(comment string) bool {
+	rest := strings.TrimPrefix(comment, ownerMarkerPrefix+":"+clusterID+":")
+	return rest != comment && !strings.Contains(rest, ":")
+}
+
+// CanAdopt reports whether an existing object with the comment may be managed
+// by this controller.
+func CanAdopt(comment string) bool {
+	return comment == "" || IsOwned(comment)
+}
+
+func notOwnedError(resourceType string, name string, comment string) error {
+	err := fmt.Errorf("%s %s is not managed by this controller (comment %q)", resourceType, name, comment)
+	log.Printf("%s", err)
+	return err
 }
 
-func AddAndBindService(lbName string, sname string, IpPort string) {
+// AddService creates the service, or marks an existing unmarked one as ours.
+func AddService(sname string, ip string, port int, protocol string, comment string, svrtimeout int) error {
 	//create a Netscaler Service that represents the Kubernetes service
 	client, _ := netscaler.NewNitroClientFromEnv()
-	ep_ip_port := strings.Split(IpPort, ":")
-	servicePort, _ := strconv.Atoi(ep_ip_port[1])
 	nsService := basic.Service{
 		Name:        sname,
-		Ip:          ep_ip_port[0],
-		Servicetype: "HTTP",
-		Port:        servicePort,
+		Ip:          ip,
+		Servicetype: protocol,
+		Port:        port,
+		Comment:     comment,
+		Svrtimeout:  svrtimeout,
 	}
-	_, err := client.AddResource(netscaler.Service.Type(), sname, &nsService)
-
-	if err == nil {
-		binding := lb.Lbvserverservicebinding{
-			Name:        lbName,
-			Servicename: sname,
+	service, err := client.FindResource(netscaler.Service.Type(), sname)
+	if err != nil {
+		_, err = client.AddResource(netscaler.Service.Type(), sname, &nsService)
+	} else if current, _ := service["comment"].(string); current != comment {
+		if !CanAdopt(current) {
+			return notOwnedError("service", sname, current)
 		}
-		_ = client.BindResource(netscaler.Lbvserver.Type(), lbName, netscaler.Service.Type(), sname, &binding)
+		_, err = client.UpdateResource(netscaler.Service.Type(), sname, &basic.Service{Name: sname, Comment: comment, Svrtimeout: svrtimeout})
 	}
+	if err != nil {
+		log.Printf("Failed to add service %s err=%s", sname, err)
+	}
+	return err
 }
 
-func ConfigureContentVServer(namespace string, csvserverName string, domainName string, path string, serviceIp string,
-	serviceName string, servicePort int, priority int, svcname_refcount map[string]int) string {
-	lbName := GenerateLbName(namespace, domainName)
-	policyName := GeneratePolicyName(namespace, domainName, path)
-	actionName := GenerateActionName(namespace, domainName, path)
+// SetServiceTimeout updates the server timeout of the service in place.
+func SetServiceTimeout(sname string, svrtimeout int) error {
 	client, _ := netscaler.NewNitroClientFromEnv()
+	_, err := client.UpdateResource(netscaler.Service.Type(), sname, &basic.Service{Name: sname, Svrtimeout: svrtimeout})
+	if err != nil {
+		log.Printf("Failed to update server timeout of service %s err=%s", sname, err)
+	}
+	return err
+}
 
-	//create a Netscaler Service that represents the Kubernetes service
-	nsService := basic.Service{
-		Name:        serviceName,
-		Ip:          serviceIp,
-		Servicetype: "HTTP",
-		Port:        servicePort,
+func DeleteService(sname string) error {
+	client, _ := netscaler.NewNitroClientFromEnv()
+	err := client.DeleteResource(netscaler.Service.Type(), sname)
+	if err != nil {
+		log.Printf("Failed to delete service %s err=%s", sname, err)
+	}
+	return err
+}
+
+// DisableService stops sending new connections to the service. The service
+// goes out of service once its connections are closed, or after delay
+// seconds.
+func DisableService(sname string, delay int) error {
+	client, _ := netscaler.NewNitroClientFromEnv()
+	service := basic.Service{
+		Name:     sname,
+		Delay:    delay,
+		Graceful: "YES",
+	}
+	err := client.ActOnResource(netscaler.Service.Type(), &service, "disable")
+	if err != nil {
+		log.Printf("Failed to disable service %s err=%s", sname, err)
 	}
-	_, _ = client.AddResource(netscaler.Service.Type(), serviceName, &nsService)
+	return err
+}
 
-	_, present := svcname_refcount[serviceName]
-	if present {
-		svcname_refcount[serviceName]++
-	} else {
-		svcname_refcount[serviceName] = 1
+func EnableService(sname string) error {
+	client, _ := netscaler.NewNitroClientFromEnv()
+	err := client.ActOnResource(netscaler.Service.Type(), &basic.Service{Name: sname}, "enable")
+	if err != nil {
+		log.Printf("Failed to enable service %s err=%s", sname, err)
 	}
+	return err
+}
 
-	//create a Netscaler "lbvserver" to front the service
-	nsLB := lb.Lbvserver{
-		Name:        lbName,
-		Servicetype: "HTTP",
+// GetServiceState returns the state of the service, such as UP or OUT OF
+// SERVICE.
+func GetServiceState(sname string) (string, error) {
+	client, _ := netscaler.NewNitroClientFromEnv()
+	service, err := client.FindResource(netscaler.Service.Type(), sname)
+	if err != nil {
+		return "", err
 	}
-	_, _ = client.AddResource(netscaler.Lbvserver.Type(), lbName, &nsLB)
+	state, _ := service["svrstate"].(string)
+	return state, nil
+}
 
-	//bind the lb to the service
+func BindService(lbName string, sname string) error {
+	client, _ := netscaler.NewNitroClientFromEnv()
 	binding := lb.Lbvserverservicebinding{
 		Name:        lbName,
-		Servicename: serviceName,
+		Servicename: sname,
+	}
+	err := client.BindResource(netscaler.Lbvserver.Type(), lbName, netscaler.Service.Type(), sname, &binding)
+	if err != nil {
+		log.Printf("Failed to bind svc %s to lb %s, err=%s", sname, lbName, err)
+	}
+	return err
+}
+
+func UnbindService(lbName string, sname string) error {
+	client, _ := netscaler.NewNitroClientFromEnv()
+	err := client.UnbindResource(netscaler.Lbvserver.Type(), lbName, netscaler.Service.Type(), sname, "servicename")
+	if err != nil {
+		log.Printf("Failed to unbind svc %s from lb %s, err=%s", sname, lbName, err)
+	}
+	return err
+}
+
+// AddServicegroup creates the servicegroup, or updates an existing one that
+// is ours or unmarked in place when its comment or server timeout differ.
+func AddServicegroup(sgName string, protocol string, comment string, svrtimeout int) error {
+	client, _ := netscaler.NewNitroClientFromEnv()
+	settings := basic.Servicegroup{
+		Servicegroupname: sgName,
+		Comment:          comment,
+		Svrtimeout:       svrtimeout,
+	}
+	servicegroup, err := client.FindResource(netscaler.Servicegroup.Type(), sgName)
+	if err != nil {
+		nsServicegroup := settings
+		nsServicegroup.Servicetype = protocol
+		_, err = client.AddResource(netscaler.Servicegroup.Type(), sgName, &nsServicegroup)
+	} else if differs(servicegroup, &settings) {
+		if current, _ := servicegroup["comment"].(string); !CanAdopt(current) {
+			return notOwnedError("servicegroup", sgName, current)
+		}
+		_, err = client.UpdateResource(netscaler.Servicegroup.Type(), sgName, &settings)
+	}
+	if err != nil {
+		log.Printf("Failed to add servicegroup %s err=%s", sgName, err)
+	}
+	return err
+}
+
+func DeleteServicegroup(sgName string) error {
+	client, _ := netscaler.NewNitroClientFromEnv()
+	err := client.DeleteResource(netscaler.Servicegroup.Type(), sgName)
+	if err != nil {
+		log.Printf("Failed to delete servicegroup %s err=%s", sgName, err)
+	}
+	return err
+}
+
+func BindServicegroup(lbName string, sgName string) error {
+	client, _ := netscaler.NewNitroClientFromEnv()
+	binding := lb.Lbvserverservicegroupbinding{
+		Name:             lbName,
+		Servicegroupname: sgName,
+	}
+	err := client.BindResource(netscaler.Lbvserver.Type(), lbName, netscaler.Servicegroup.Type(), sgName, &binding)
+	if err != nil {
+		log.Printf("Failed to bind servicegroup %s to lb %s, err=%s", sgName, lbName, err)
+	}
+	return err
+}
+
+func ListBoundServicegroupsForLB(lbName string) ([]string, error) {
+	client, _ := netscaler.NewNitroClientFromEnv()
+	bindings, err := client.ListBoundResources(netscaler.Lbvserver.Type(), lbName, netscaler.Servicegroup.Type())
+	ret := []string{}
+	if err != nil {
+		log.Printf("Failed to list servicegroup bindings for LB Vserver %s, err=%s", lbName, err)
+		return ret, err
+	}
+	for _, b := range bindings {
+		if sgName, ok := b["servicegroupname"].(string); ok {
+			ret = append(ret, sgName)
+		}
+	}
+	return ret, nil
+}
+
+// servicegroupMember is an IP and port load balanced by a servicegroup.
+type servicegroupMember struct {
+	IP   string
+	Port int
+}
+
+// Servicegroup members are not resources of their own, so they are added and
+// removed through their binding directly.
+func AddServicegroupMember(sgName string, member servicegroupMember) error {
+	client, _ := netscaler.NewNitroClientFromEnv()
+	binding := basic.Servicegroupservicegroupmemberbinding{
+		Servicegroupname: sgName,
+		Ip:               member.IP,
+		Port:             member.Port,
+	}
+	err := client.AddBinding(netscaler.Servicegroup_servicegroupmember_binding.Type(), &binding)
+	if err != nil {
+		log.Printf("Failed to add member %s:%d to servicegroup %s, err=%s", member.IP, member.Port, sgName, err)
+	}
+	return err
+}
+
+func DeleteServicegroupMember(sgName string, member servicegroupMember) error {
+	client, _ := netscaler.NewNitroClientFromEnv()
+	args := fmt.Sprintf("ip:%s,port:%d", member.IP, member.Port)
+	err := client.DeleteResourceWithArgs(netscaler.Servicegroup_servicegroupmember_binding.Type(), sgName, []string{args})
+	if err != nil {
+		log.Printf("Failed to remove member %s:%d from servicegroup %s, err=%s", member.IP, member.Port, sgName, err)
+	}
+	return err
+}
+
+// Members added by IP are known by their IP as server name.
+func DisableServicegroupMember(sgName string, member servicegroupMember, delay int) error {
+	client, _ := netscaler.NewNitroClientFromEnv()
+	servicegroup := basic.Servicegroup{
+		Servicegroupname: sgName,
+		Servername:       member.IP,
+		Port:             member.Port,
+		Delay:            delay,
+		Graceful:         "YES",
+	}
+	err := client.ActOnResource(netscaler.Servicegroup.Type(), &servicegroup, "disable")
+	if err != nil {
+		log.Printf("Failed to disable member %s:%d of servicegroup %s, err=%s", member.IP, member.Port, sgName, err)
+	}
+	return err
+}
+
+func EnableServicegroupMember(sgName string, member servicegroupMember) error {
+	client, _ := netscaler.NewNitroClientFromEnv()
+	servicegroup := basic.Servicegroup{
+		Servicegroupname: sgName,
+		Servername:       member.IP,
+		Port:             member.Port,
+	}
+	err := client.ActOnResource(netscaler.Servicegroup.Type(), &servicegroup, "enable")
+	if err != nil {
+		log.Printf("Failed to enable member %s:%d of servicegroup %s, err=%s", member.IP, member.Port, sgName, err)
+	}
+	return err
+}
+
+// ListServicegroupMembers returns the state of every member of the
+// servicegroup.
+func ListServicegroupMembers(sgName string) (map[servicegroupMember]string, error) {
+	client, _ := netscaler.NewNitroClientFromEnv()
+	bindings, err := client.ListBoundResources(netscaler.Servicegroup.Type(), sgName, "servicegroupmember")
+	ret := make(map[servicegroupMember]string)
+	if err != nil {
+		log.Printf("Failed to list members of servicegroup %s, err=%s", sgName, err)
+		return ret, err
+	}
+	for _, b := range bindings {
+		ip, _ := b["ip"].(string)
+		port, err := strconv.Atoi(fmt.Sprint(b["port"]))
+		if ip == "" || err != nil {
+			continue
+		}
+		state, _ := b["svrstate"].(string)
+		ret[servicegroupMember{IP: ip, Port: port}] = state
+	}
+	return ret, nil
+}
+
+// AddLbVServer creates the lb vserver with the settings, or updates an
+// existing one that is ours or unmarked in place when its comment or settings
+// differ.
+func AddLbVServer(lbName string, protocol string, comment string, settings lb.Lbvserver) error {
+	//create a Netscaler "lbvserver" to front the service
+	client, _ := netscaler.NewNitroClientFromEnv()
+	settings.Name = lbName
+	settings.Comment = comment
+	vserver, err := client.FindResource(netscaler.Lbvserver.Type(), lbName)
+	if err != nil {
+		nsLB := settings
+		nsLB.Servicetype = protocol
+		_, err = client.AddResource(netscaler.Lbvserver.Type(), lbName, &nsLB)
+	} else {
+		// Empty settings are left out of updates, the ones that are set
+		// on the lb vserver have to be unset explicitly
+		unset := map[string]interface{}{"name": lbName}
+		for field, value := range map[string]string{
+			"backupvserver": settings.Backupvserver,
+			"cookiename":    settings.Cookiename,
+			"rule":          settings.Rule,
+		} {
+			current, _ := vserver[field].(string)
+			if value == "" && current != "" && !strings.EqualFold(current, "none") {
+				unset[field] = true
+			}
+		}
+		if !differs(vserver, &settings) && len(unset) == 1 {
+			return nil
+		}
+		if current, _ := vserver["comment"].(string); !CanAdopt(current) {
+			return notOwnedError("lb vserver", lbName, current)
+		}
+		log.Printf("Updating settings of lb %s", lbName)
+		_, err = client.UpdateResource(netscaler.Lbvserver.Type(), lbName, &settings)
+		if err == nil && len(unset) > 1 {
+			err = client.ActOnResource(netscaler.Lbvserver.Type(), unset, "unset")
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to add lb %s, err=%s", lbName, err)
+	}
+	return err
+}
+
+// differs reports whether any field set in desired has another value in the
+// resource as NITRO returns it. NITRO returns numbers as strings at times and
+// keywords in any case.
+func differs(resource map[string]interface{}, desired interface{}) bool {
+	raw, err := json.Marshal(desired)
+	if err != nil {
+		return true
+	}
+	fields := make(map[string]interface{})
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return true
+	}
+	for field, value := range fields {
+		if !strings.EqualFold(fmt.Sprint(resource[field]), fmt.Sprint(value)) {
+			return true
+		}
 	}
-	_ = client.BindResource(netscaler.Lbvserver.Type(), lbName, netscaler.Service.Type(), serviceName, &binding)
+	return false
+}
 
-	//create a content switch action to switch to the lb
+func DeleteLbVServer(lbName string) error {
+	client, _ := netscaler.NewNitroClientFromEnv()
+	err := client.DeleteResource(netscaler.Lbvserver.Type(), lbName)
+	if err != nil {
+		log.Printf("Failed to delete lb %s, err=%s", lbName, err)
+	}
+	return err
+}
+
+// AddOrUpdateCsAction makes sure the content switching action exists, carries
+// the comment and switches to the supplied lb vserver.
+func AddOrUpdateCsAction(actionName string, lbName string, comment string) error {
+	client, _ := netscaler.NewNitroClientFromEnv()
 	csAction := cs.Csaction{
 		Name:            actionName,
 		Targetlbvserver: lbName,
+		Comment:         comment,
 	}
-	_, _ = client.AddResource(netscaler.Csaction.Type(), actionName, &csAction)
-
-	//create a content switch policy to use the action
-	var rule string
-	if path != "" {
-		rule = fmt.Sprintf("HTTP.REQ.HOSTNAME.EQ(\"%s\") && HTTP.REQ.URL.PATH.EQ(\"%s\")", domainName, path)
-	} else {
-		rule = fmt.Sprintf("HTTP.REQ.HOSTNAME.EQ(\"%s\")", domainName)
+	action, err := client.FindResource(netscaler.Csaction.Type(), actionName)
+	if err != nil {
+		_, err = client.AddResource(netscaler.Csaction.Type(), actionName, &csAction)
+	} else if current, _ := action["comment"].(string); !CanAdopt(current) {
+		return notOwnedError("cs action", actionName, current)
+	} else if action["targetlbvserver"] != lbName || current != comment {
+		_, err = client.UpdateResource(netscaler.Csaction.Type(), actionName, &csAction)
 	}
+	if err != nil {
+		log.Printf("Failed to configure Content Switching Action %s for LB %s err=%s", actionName, lbName, err)
+	}
+	return err
+}
+
+// AddOrUpdateCsPolicy makes sure the content switching policy exists with the
+// supplied rule and action. Policies have no comment, a policy belongs to
+// whoever owns its action.
+func AddOrUpdateCsPolicy(policyName string, rule string, actionName string) error {
+	client, _ := netscaler.NewNitroClientFromEnv()
 	csPolicy := cs.Cspolicy{
 		Policyname: policyName,
 		Rule:       rule,
 		Action:     actionName,
 	}
-	_, _ = client.AddResource(netscaler.Cspolicy.Type(), policyName, &csPolicy)
+	policy, err := client.FindResource(netscaler.Cspolicy.Type(), policyName)
+	if err != nil {
+		_, err = client.AddResource(netscaler.Cspolicy.Type(), policyName, &csPolicy)
+	} else if current, _ := policy["action"].(string); current != actionName && !CanAdopt(csActionComment(current)) {
+		return notOwnedError("cs policy", policyName, csActionComment(current))
+	} else if policy["rule"] != rule || policy["action"] != actionName {
+		_, err = client.UpdateResource(netscaler.Cspolicy.Type(), policyName, &csPolicy)
+	}
+	if err != nil {
+		log.Printf("Failed to configure Content Switching Policy %s err=%s", policyName, err)
+	}
+	return err
+}
+
+func csActionComment(actionName string) string {
+	client, _ := netscaler.NewNitroClientFromEnv()
+	action, err := client.FindResource(netscaler.Csaction.Type(), actionName)
+	if err != nil {
+		return ""
+	}
+	comment, _ := action["comment"].(string)
+	return comment
+}
 
+func BindCsPolicy(csvserverName string, policyName string, priority int) error {
 	//bind the content switch policy to the content switching vserver
-	binding2 := cs.Csvservercspolicybinding{
+	client, _ := netscaler.NewNitroClientFromEnv()
+	binding := cs.Csvservercspolicybinding{
 		Name:       csvserverName,
 		Policyname: policyName,
 		Priority:   priority,
 		Bindpoint:  "REQUEST",
 	}
-	_ = client.BindResource(netscaler.Csvserver.Type(), csvserverName, netscaler.Cspolicy.Type(), policyName, &binding2)
+	err := client.BindResource(netscaler.Csvserver.Type(), csvserverName, netscaler.Cspolicy.Type(), policyName, &binding)
+	if err != nil {
+		log.Printf("Failed to bind Content Switching Policy %s to Content Switching VServer %s, err=%s", policyName, csvserverName, err)
+	}
+	return err
+}
 
-	return lbName
+// GetDefaultLbVserver returns the lb vserver the content switching vserver
+// sends requests no policy matches to, or "" when it has none.
+func GetDefaultLbVserver(csvserverName string) (string, error) {
+	client, _ := netscaler.NewNitroClientFromEnv()
+	bindings, err := client.ListBoundResources(netscaler.Csvserver.Type(), csvserverName, netscaler.Lbvserver.Type())
+	if err != nil {
+		log.Printf("Failed to list lb vserver bindings for CS Vserver %s, err=%s", csvserverName, err)
+		return "", err
+	}
+	if len(bindings) == 0 {
+		return "", nil
+	}
+	lbName, _ := bindings[0]["lbvserver"].(string)
+	return lbName, nil
+}
+
+// BindDefaultLbVserver makes the lb vserver the default of the content
+// switching vserver.
+func BindDefaultLbVserver(csvserverName string, lbName string) error {
+	client, _ := netscaler.NewNitroClientFromEnv()
+	binding := cs.Csvserverlbvserverbinding{
+		Name:      csvserverName,
+		Lbvserver: lbName,
+	}
+	err := client.BindResource(netscaler.Csvserver.Type(), csvserverName, netscaler.Lbvserver.Type(), lbName, &binding)
+	if err != nil {
+		log.Printf("Failed to bind default LB %s to Content Switching VServer %s, err=%s", lbName, csvserverName, err)
+	}
+	return err
+}
+
+// UnbindDefaultLbVserver removes the default lb vserver of the content
+// switching vserver.
+func UnbindDefaultLbVserver(csvserverName string, lbName string) error {
+	client, _ := netscaler.NewNitroClientFromEnv()
+	err := client.UnbindResource(netscaler.Csvserver.Type(), csvserverName, netscaler.Lbvserver.Type(), lbName, "lbvserver")
+	if err != nil {
+		log.Printf("Failed to unbind default LB %s from Content Switching VServer %s, err=%s", lbName, csvserverName, err)
+	}
+	return err
 }
 
-func CreateContentVServer(csvserverName string, vserverIp string, vserverPort int, protocol string) error {
+// UnbindCsPolicy unbinds the content switching policy from the content
+// switching vserver, leaving the policy in place.
+func UnbindCsPolicy(csvserverName string, policyName string) error {
+	client, _ := netscaler.NewNitroClientFromEnv()
+	err := client.UnbindResource(netscaler.Csvserver.Type(), csvserverName, netscaler.Cspolicy.Type(), policyName, "policyName")
+	if err != nil {
+		log.Printf("Failed to unbind Content Switching Policy %s from Content Switching VServer %s, err=%s", policyName, csvserverName, err)
+	}
+	return err
+}
+
+func CreateContentVServer(csvserverName string, vserverIp string, vserverPort int, protocol string, comment string) error {
 	client, _ := netscaler.NewNitroClientFromEnv()
 	cs := cs.Csvserver{
 		Name:        csvserverName,
 		Ipv46:       vserverIp,
 		Servicetype: protocol,
 		Port:        vserverPort,
+		Comment:     comment,
+	}
+	_, err := client.AddResource(netscaler.Csvserver.Type(), csvserverName, &cs)
+	if err != nil {
+		log.Printf("Failed to create content vserver %s err=%s", csvserverName, err)
+	}
+	return err
+}
+
+func UpdateContentVServer(csvserverName string, vserverIp string, vserverPort int, comment string) error {
+	client, _ := netscaler.NewNitroClientFromEnv()
+	cs := cs.Csvserver{
+		Name:    csvserverName,
+		Ipv46:   vserverIp,
+		Port:    vserverPort,
+		Comment: comment,
+	}
+	_, err := client.UpdateResource(netscaler.Csvserver.Type(), csvserverName, &cs)
+	if err != nil {
+		log.Printf("Failed to update content vserver %s err=%s", csvserverName, err)
+		return err
 	}
-	_, _ = client.AddResource(netscaler.Csvserver.Type(), csvserverName, &cs)
 	return nil
 }
 
-func DeleteContentVServer(csvserverName string, svcname_refcount map[string]int, lbName_map map[string]int) {
+// DeleteContentVServerPolicy unbinds a content switching policy from the content
+// switching vserver and deletes the policy along with its action, unless the
+// policy is still bound to another content switching vserver. The name of the
+// lb vserver the action switched to is returned so that the caller can decide
+// whether the lb vserver is still in use.
+func DeleteContentVServerPolicy(csvserverName string, policyName string) (string, error) {
 	client, _ := netscaler.NewNitroClientFromEnv()
-	policyNames, _ := ListBoundPolicies(csvserverName)
 
-	for _, policyName := range policyNames {
-		//unbind the content switch policy from the content switching vserver
-		err := client.UnbindResource(netscaler.Csvserver.Type(), csvserverName, netscaler.Cspolicy.Type(), policyName, "policyName")
-		if err != nil {
-			log.Fatal(fmt.Sprintf("Failed to unbind Content Switching Policy %s fromo Content Switching VServer %s, err=%s", policyName, csvserverName, err))
-			continue
-		}
+	if err := UnbindCsPolicy(csvserverName, policyName); err != nil {
+		return "", err
+	}
+
+	//the policy may still be bound to the other content vserver of the ingress
+	bindings, err := client.ListBoundResources(netscaler.Cspolicy.Type(), policyName, netscaler.Csvserver.Type())
+	if err != nil {
+		log.Printf("Failed to list bindings of Content Switching Policy %s, err=%s", policyName, err)
+		return "", err
+	}
+	if len(bindings) > 0 {
+		return "", nil
+	}
 
-		//find the action name from the policy
-		actionName := ListPolicyAction(policyName)
+	//find the action name from the policy
+	actionName := ListPolicyAction(policyName)
 
-		err = client.DeleteResource(netscaler.Cspolicy.Type(), policyName)
+	err = client.DeleteResource(netscaler.Cspolicy.Type(), policyName)
+	if err != nil {
+		log.Printf("Failed to delete Content Switching Policy %s, err=%s", policyName, err)
+		return "", err
+	}
+	//find the lb name associated with the action
+	lbName, err := ListLbVserverForAction(actionName)
+	if err != nil {
+		log.Printf("Failed to obtain lb name for cs action %s", actionName)
+		return "", err
+	}
+	//delete content switch action that switches to the lb
+	err = client.DeleteResource(netscaler.Csaction.Type(), actionName)
+	if err != nil {
+		log.Printf("Failed to delete Content Switching Action %s for LB %s err=%s", actionName, lbName, err)
+		return "", err
+	}
+	return lbName, nil
+}
+
+// DeleteCsAction deletes the content switching action together with the
+// policies using it. The action is kept while one of those policies is still
+// bound to a content switching vserver.
+func DeleteCsAction(actionName string) error {
+	client, _ := netscaler.NewNitroClientFromEnv()
+	policies, err := client.FindAllResources(netscaler.Cspolicy.Type())
+	if err != nil {
+		log.Printf("Failed to list Content Switching Policies, err=%s", err)
+		return err
+	}
+	for _, policy := range policies {
+		policyName, ok := policy["policyname"].(string)
+		if !ok || policy["action"] != actionName {
+			continue
+		}
+		bindings, err := client.ListBoundResources(netscaler.Cspolicy.Type(), policyName, netscaler.Csvserver.Type())
 		if err != nil {
+			log.Printf("Failed to list bindings of Content Switching Policy %s, err=%s", policyName, err)
+			return err
+		}
+		if len(bindings) > 0 {
+			return fmt.Errorf("cs action %s is used by policy %s, which is still bound", actionName, policyName)
+		}
+		if err := client.DeleteResource(netscaler.Cspolicy.Type(), policyName); err != nil {
 			log.Printf("Failed to delete Content Switching Policy %s, err=%s", policyName, err)
-			continue
+			return err
 		}
-		//find the lb name associated with the action
-		lbName, err := ListLbVserverForAction(actionName)
+	}
+	err = client.DeleteResource(netscaler.Csaction.Type(), actionName)
+	if err != nil {
+		log.Printf("Failed to delete Content Switching Action %s, err=%s", actionName, err)
+	}
+	return err
+}
 
+// DeleteContentVServer removes the content switching vserver together with its
+// policies and actions. The lb vservers and services the actions switched to
+// are left for the garbage collection of the reconciler since they may be
+// shared with other ingresses.
+func DeleteContentVServer(csvserverName string) error {
+	client, _ := netscaler.NewNitroClientFromEnv()
+	policies, err := ListBoundPolicies(csvserverName)
+	if err != nil {
+		return err
+	}
+
+	for policyName := range policies {
+		_, err = DeleteContentVServerPolicy(csvserverName, policyName)
 		if err != nil {
-			log.Printf("Failed to obtain lb name for cs action %s", actionName)
-			continue
+			return err
 		}
-		//delete content switch action that switches to the lb
-		err = client.DeleteResource(netscaler.Csaction.Type(), actionName)
-		if err != nil {
-			log.Fatal(fmt.Sprintf("Failed to delete Content Switching Action %s for LB %s err=%s", actionName, lbName, err))
-			return
+	}
+	err = client.DeleteResource(netscaler.Csvserver.Type(), csvserverName)
+	if err != nil {
+		log.Printf("Failed to delete content vserver %s err=%s", csvserverName, err)
+	}
+	return err
+}
+
+// GetContentVServer returns the IP address, port, protocol and comment the
+// content switching vserver is currently configured with.
+func GetContentVServer(csvserverName string) (string, int, string, string, error) {
+	client, _ := netscaler.NewNitroClientFromEnv()
+	vserver, err := client.FindResource(netscaler.Csvserver.Type(), csvserverName)
+	if err != nil {
+		return "", 0, "", "", err
+	}
+	ip, _ := vserver["ipv46"].(string)
+	protocol, _ := vserver["servicetype"].(string)
+	comment, _ := vserver["comment"].(string)
+	var port int
+	switch p := vserver["port"].(type) {
+	case float64:
+		port = int(p)
+	case string:
+		port, _ = strconv.Atoi(p)
+	}
+	return ip, port, protocol, comment, nil
+}
+
+// The vendored go-nitro has no config/ssl or config/system package, these
+// mirror the NITRO attributes used by the controller.
+
+type systemFile struct {
+	Filename     string `json:"filename,omitempty"`
+	Filelocation string `json:"filelocation,omitempty"`
+	Filecontent  string `json:"filecontent,omitempty"`
+	Fileencoding string `json:"fileencoding,omitempty"`
+}
+
+type sslCertKey struct {
+	Certkey       string `json:"certkey,omitempty"`
+	Cert          string `json:"cert,omitempty"`
+	Key           string `json:"key,omitempty"`
+	Nodomaincheck bool   `json:"nodomaincheck,omitempty"`
+}
+
+type sslVserver struct {
+	Vservername string `json:"vservername,omitempty"`
+	Snienable   string `json:"snienable,omitempty"`
+}
+
+type sslVserverCertKeyBinding struct {
+	Vservername string `json:"vservername,omitempty"`
+	Certkeyname string `json:"certkeyname,omitempty"`
+	Snicert     bool   `json:"snicert,omitempty"`
+}
+
+const sslFileLocation = "/nsconfig/ssl"
+
+// UploadSslFile stores a certificate or key file in /nsconfig/ssl. File names
+// are expected to be derived from the content, so a file that already exists
+// is left alone.
+func UploadSslFile(fileName string, content []byte) error {
+	client, _ := netscaler.NewNitroClientFromEnv()
+	file := systemFile{
+		Filename:     fileName,
+		Filelocation: sslFileLocation,
+		Filecontent:  base64.StdEncoding.EncodeToString(content),
+		Fileencoding: "BASE64",
+	}
+	_, err := client.AddResource(netscaler.Systemfile.Type(), fileName, &file)
+	if err != nil && strings.Contains(err.Error(), "409 Conflict") {
+		return nil
+	}
+	if err != nil {
+		log.Printf("Failed to upload file %s err=%s", fileName, err)
+	}
+	return err
+}
+
+// DeleteSslFile removes a file from /nsconfig/ssl. Failures are only logged,
+// a file left behind does no harm.
+func DeleteSslFile(fileName string) {
+	client, _ := netscaler.NewNitroClientFromEnv()
+	args := "filelocation:" + strings.Replace(sslFileLocation, "/", "%2F", -1)
+	if err := client.DeleteResourceWithArgsUnchecked(netscaler.Systemfile.Type(), fileName, []string{args}); err != nil {
+		log.Printf("Failed to delete file %s err=%s", fileName, err)
+	}
+}
+
+// AddOrUpdateCertKey makes sure the certkey exists and refers to the supplied
+// certificate and key files. An existing certkey is updated in place so that
+// the vservers it is bound to keep serving.
+func AddOrUpdateCertKey(certkeyName string, certFile string, keyFile string) error {
+	client, _ := netscaler.NewNitroClientFromEnv()
+	certkey := sslCertKey{
+		Certkey: certkeyName,
+		Cert:    certFile,
+		Key:     keyFile,
+	}
+	existing, err := client.FindResource(netscaler.Sslcertkey.Type(), certkeyName)
+	if err != nil {
+		_, err = client.AddResource(netscaler.Sslcertkey.Type(), certkeyName, &certkey)
+	} else if oldCert, oldKey := certKeyFiles(existing); oldCert != certFile || oldKey != keyFile {
+		log.Printf("Replacing certificate of certkey %s with %s", certkeyName, certFile)
+		certkey.Nodomaincheck = true
+		err = client.ActOnResource(netscaler.Sslcertkey.Type(), &certkey, "update")
+		if err == nil {
+			// The files of the previous certificate are only used by
+			// this certkey
+			DeleteSslFile(oldCert)
+			DeleteSslFile(oldKey)
 		}
+	}
+	if err != nil {
+		log.Printf("Failed to configure certkey %s err=%s", certkeyName, err)
+	}
+	return err
+}
 
-		//find the service names that the LB is bound to
-		serviceNames, err := ListBoundServicesForLB(lbName)
-		if err != nil {
-			log.Printf("Failed to retrieve services bound to LB " + lbName)
-			continue
+// DeleteCertKey removes the certkey together with its certificate and key
+// files.
+func DeleteCertKey(certkeyName string) error {
+	client, _ := netscaler.NewNitroClientFromEnv()
+	existing, err := client.FindResource(netscaler.Sslcertkey.Type(), certkeyName)
+	if err != nil {
+		return nil
+	}
+	err = client.DeleteResource(netscaler.Sslcertkey.Type(), certkeyName)
+	if err != nil {
+		log.Printf("Failed to delete certkey %s err=%s", certkeyName, err)
+		return err
+	}
+	certFile, keyFile := certKeyFiles(existing)
+	DeleteSslFile(certFile)
+	DeleteSslFile(keyFile)
+	return nil
+}
+
+// certKeyFiles returns the names of the certificate and key files a certkey
+// refers to.
+func certKeyFiles(certkey map[string]interface{}) (string, string) {
+	return path.Base(fmt.Sprint(certkey["cert"])), path.Base(fmt.Sprint(certkey["key"]))
+}
+
+func ListCertKeys() ([]string, error) {
+	result := []string{}
+	client, _ := netscaler.NewNitroClientFromEnv()
+	certkeys, err := client.FindAllResources(netscaler.Sslcertkey.Type())
+	if err != nil {
+		return result, err
+	}
+	for _, c := range certkeys {
+		if name, ok := c["certkey"].(string); ok {
+			result = append(result, name)
 		}
-		for _, sname := range serviceNames {
-			err = client.UnbindResource(netscaler.Lbvserver.Type(), lbName, netscaler.Service.Type(), sname, "servicename")
+	}
+	return result, nil
+}
 
-			if err != nil {
-				log.Fatal(fmt.Sprintf("Failed to unbind svc %s from lb %s, err=%s", sname, lbName, err))
-				continue
-			}
+// CertKeyInUse reports whether the certkey is bound to any SSL vserver.
+func CertKeyInUse(certkeyName string) (bool, error) {
+	client, _ := netscaler.NewNitroClientFromEnv()
+	bindings, err := client.ListBoundResources(netscaler.Sslcertkey.Type(), certkeyName, netscaler.Sslvserver.Type())
+	if err != nil {
+		log.Printf("Failed to list SSL vserver bindings for certkey %s, err=%s", certkeyName, err)
+		return false, err
+	}
+	return len(bindings) > 0, nil
+}
+
+func SetContentVServerSni(csvserverName string, enable bool) error {
+	client, _ := netscaler.NewNitroClientFromEnv()
+	current, err := client.FindResource(netscaler.Sslvserver.Type(), csvserverName)
+	if err == nil && strings.EqualFold(fmt.Sprint(current["snienable"]), "ENABLED") == enable {
+		return nil
+	}
+	vserver := sslVserver{
+		Vservername: csvserverName,
+		Snienable:   "DISABLED",
+	}
+	if enable {
+		vserver.Snienable = "ENABLED"
+	}
+	_, err = client.UpdateResource(netscaler.Sslvserver.Type(), csvserverName, &vserver)
+	if err != nil {
+		log.Printf("Failed to set SNI on content vserver %s err=%s", csvserverName, err)
+	}
+	return err
+}
+
+// ListBoundCertKeys returns the certkeys bound to the SSL vserver, a certkey
+// bound both as SNI and as default certificate is returned twice.
+func ListBoundCertKeys(csvserverName string) ([]certKeyBinding, error) {
+	ret := []certKeyBinding{}
+	client, _ := netscaler.NewNitroClientFromEnv()
+	bindings, err := client.ListBoundResources(netscaler.Sslvserver.Type(), csvserverName, netscaler.Sslcertkey.Type())
+	if err != nil {
+		log.Printf("Failed to list certkey bindings for SSL vserver %s, err=%s", csvserverName, err)
+		return ret, err
+	}
+	for _, b := range bindings {
+		name, ok := b["certkeyname"].(string)
+		if !ok {
+			continue
 		}
+		ret = append(ret, certKeyBinding{Name: name, SNI: fmt.Sprint(b["snicert"]) == "true"})
+	}
+	return ret, nil
+}
+
+func BindCertKey(csvserverName string, certkeyName string, sni bool) error {
+	client, _ := netscaler.NewNitroClientFromEnv()
+	binding := sslVserverCertKeyBinding{
+		Vservername: csvserverName,
+		Certkeyname: certkeyName,
+		Snicert:     sni,
+	}
+	err := client.BindResource(netscaler.Sslvserver.Type(), csvserverName, netscaler.Sslcertkey.Type(), certkeyName, &binding)
+	if err != nil {
+		log.Printf("Failed to bind certkey %s to SSL vserver %s err=%s", certkeyName, csvserverName, err)
+	}
+	return err
+}
+
+func UnbindCertKey(csvserverName string, certkeyName string, sni bool) error {
+	client, _ := netscaler.NewNitroClientFromEnv()
+	// SNI bindings can only be removed when the unbind names them as such,
+	// which UnbindResource has no way of expressing
+	args := "certkeyname:" + certkeyName
+	if sni {
+		args += ",snicert:true"
+	}
+	err := client.DeleteResourceWithArgs(netscaler.Sslvserver_sslcertkey_binding.Type(), csvserverName, []string{args})
+	if err != nil {
+		log.Printf("Failed to unbind certkey %s from SSL vserver %s err=%s", certkeyName, csvserverName, err)
+	}
+	return err
+}
+
+// AddMonitor creates the monitor. Monitors are named after their settings, so
+// an existing one is already configured as wanted.
+func AddMonitor(monitor *lb.Lbmonitor) error {
+	client, _ := netscaler.NewNitroClientFromEnv()
+	_, err := client.AddResource(netscaler.Lbmonitor.Type(), monitor.Monitorname, monitor)
+	if err != nil {
+		log.Printf("Failed to add monitor %s err=%s", monitor.Monitorname, err)
+	}
+	return err
+}
 
-		//delete  "lbvserver" that fronts the service
-		err = client.DeleteResource(netscaler.Lbvserver.Type(), lbName)
+// DeleteMonitor removes the monitor, NITRO needs its type to identify it.
+func DeleteMonitor(monitorName string, monitorType string) error {
+	client, _ := netscaler.NewNitroClientFromEnv()
+	err := client.DeleteResourceWithArgs(netscaler.Lbmonitor.Type(), monitorName, []string{"type:" + monitorType})
+	if err != nil {
+		log.Printf("Failed to delete monitor %s err=%s", monitorName, err)
+	}
+	return err
+}
 
-		if lbName_map != nil {
-			delete(lbName_map, lbName)
+// GetMonitorType returns the type of the monitor.
+func GetMonitorType(monitorName string) (string, error) {
+	client, _ := netscaler.NewNitroClientFromEnv()
+	monitor, err := client.FindResource(netscaler.Lbmonitor.Type(), monitorName)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprint(monitor["type"]), nil
+}
+
+// ListMonitors returns the type of every monitor, keyed by monitor name.
+func ListMonitors() (map[string]string, error) {
+	ret := make(map[string]string)
+	client, _ := netscaler.NewNitroClientFromEnv()
+	monitors, err := client.FindAllResources(netscaler.Lbmonitor.Type())
+	if err != nil {
+		return ret, err
+	}
+	for _, m := range monitors {
+		if name, ok := m["monitorname"].(string); ok {
+			ret[name] = fmt.Sprint(m["type"])
 		}
+	}
+	return ret, nil
+}
 
-		//Delete the Netscaler Services
-		for _, sname := range serviceNames {
+func BindMonitor(sname string, monitorName string) error {
+	client, _ := netscaler.NewNitroClientFromEnv()
+	binding := basic.Servicelbmonitorbinding{
+		Name:        sname,
+		Monitorname: monitorName,
+	}
+	err := client.BindResource(netscaler.Service.Type(), sname, netscaler.Lbmonitor.Type(), monitorName, &binding)
+	if err != nil {
+		log.Printf("Failed to bind monitor %s to svc %s, err=%s", monitorName, sname, err)
+	}
+	return err
+}
 
-			_, present := svcname_refcount[sname]
-			if present {
-				svcname_refcount[sname]--
-			}
+func UnbindMonitor(sname string, monitorName string) error {
+	client, _ := netscaler.NewNitroClientFromEnv()
+	err := client.UnbindResource(netscaler.Service.Type(), sname, netscaler.Lbmonitor.Type(), monitorName, "monitor_name")
+	if err != nil {
+		log.Printf("Failed to unbind monitor %s from svc %s, err=%s", monitorName, sname, err)
+	}
+	return err
+}
 
-			if svcname_refcount[sname] == 0 {
-				delete(svcname_refcount, sname)
-				err = client.DeleteResource(netscaler.Service.Type(), sname)
-				if err != nil {
-					log.Println(fmt.Sprintf("Failed to delete service %s err=%s", sname, err))
-					continue
-				}
-			}
+func BindServicegroupMonitor(sgName string, monitorName string) error {
+	client, _ := netscaler.NewNitroClientFromEnv()
+	binding := basic.Servicegrouplbmonitorbinding{
+		Servicegroupname: sgName,
+		Monitorname:      monitorName,
+	}
+	err := client.BindResource(netscaler.Servicegroup.Type(), sgName, netscaler.Lbmonitor.Type(), monitorName, &binding)
+	if err != nil {
+		log.Printf("Failed to bind monitor %s to servicegroup %s, err=%s", monitorName, sgName, err)
+	}
+	return err
+}
+
+func UnbindServicegroupMonitor(sgName string, monitorName string) error {
+	client, _ := netscaler.NewNitroClientFromEnv()
+	err := client.UnbindResource(netscaler.Servicegroup.Type(), sgName, netscaler.Lbmonitor.Type(), monitorName, "monitor_name")
+	if err != nil {
+		log.Printf("Failed to unbind monitor %s from servicegroup %s, err=%s", monitorName, sgName, err)
+	}
+	return err
+}
+
+// ListBoundMonitorsForService returns the monitors probing the service.
+func ListBoundMonitorsForService(sname string) ([]string, error) {
+	client, _ := netscaler.NewNitroClientFromEnv()
+	bindings, err := client.ListBoundResources(netscaler.Service.Type(), sname, netscaler.Lbmonitor.Type())
+	ret := []string{}
+	if err != nil {
+		log.Printf("Failed to list monitor bindings for svc %s, err=%s", sname, err)
+		return ret, err
+	}
+	for _, b := range bindings {
+		if monitorName, ok := b["monitor_name"].(string); ok {
+			ret = append(ret, monitorName)
 		}
 	}
-	_ = client.DeleteResource(netscaler.Csvserver.Type(), csvserverName)
+	return ret, nil
 }
 
-func FindContentVserver(csvserverName string) bool {
+func ListBoundMonitorsForServicegroup(sgName string) ([]string, error) {
 	client, _ := netscaler.NewNitroClientFromEnv()
-	return client.ResourceExists(netscaler.Csvserver.Type(), csvserverName)
+	bindings, err := client.ListBoundResources(netscaler.Servicegroup.Type(), sgName, netscaler.Lbmonitor.Type())
+	ret := []string{}
+	if err != nil {
+		log.Printf("Failed to list monitor bindings for servicegroup %s, err=%s", sgName, err)
+		return ret, err
+	}
+	for _, b := range bindings {
+		if monitorName, ok := b["monitor_name"].(string); ok {
+			ret = append(ret, monitorName)
+		}
+	}
+	return ret, nil
 }
 
-func ListContentVservers() []string {
-	result := []string{}
+// ListBoundServicegroupsForMonitor returns the servicegroups the monitor
+// probes.
+func ListBoundServicegroupsForMonitor(monitorName string) ([]string, error) {
 	client, _ := netscaler.NewNitroClientFromEnv()
+	bindings, err := client.ListBoundResources(netscaler.Lbmonitor.Type(), monitorName, netscaler.Servicegroup.Type())
+	ret := []string{}
+	if err != nil {
+		log.Printf("Failed to list servicegroup bindings for monitor %s, err=%s", monitorName, err)
+		return ret, err
+	}
+	for _, b := range bindings {
+		if sgName, ok := b["servicegroupname"].(string); ok {
+			ret = append(ret, sgName)
+		}
+	}
+	return ret, nil
+}
 
-	vservers, err := client.FindAllResources(netscaler.Csvserver.Type())
+// ListBoundServicesForMonitor returns the services the monitor probes.
+func ListBoundServicesForMonitor(monitorName string) ([]string, error) {
+	client, _ := netscaler.NewNitroClientFromEnv()
+	bindings, err := client.ListBoundResources(netscaler.Lbmonitor.Type(), monitorName, netscaler.Service.Type())
+	ret := []string{}
 	if err != nil {
-		log.Printf("Failed to find any resources of type content vserver")
-		return result
+		log.Printf("Failed to list service bindings for monitor %s, err=%s", monitorName, err)
+		return ret, err
 	}
-	for _, c := range vservers {
-		csname := c["name"].(string)
-		result = append(result, csname)
+	for _, b := range bindings {
+		if sname, ok := b["servicename"].(string); ok {
+			ret = append(ret, sname)
+		}
 	}
-	return result
+	return ret, nil
+}
+
+// ListStats returns the statistics of all objects of the resource type, such
+// as lbvserver, csvserver or service.
+func ListStats(resourceType string) ([]map[string]interface{}, error) {
+	client, _ := netscaler.NewNitroClientFromEnv()
+	stats, err := client.FindAllStats(resourceType)
+	if err != nil {
+		log.Printf("Failed to read %s statistics err=%s", resourceType, err)
+	}
+	return stats, err
+}
+
+// NitroError is the error NITRO responds with. go-nitro only passes it on as
+// part of the text of its errors.
+type NitroError struct {
+	Errorcode int    `json:"errorcode"`
+	Message   string `json:"message"`
+	Severity  string `json:"severity"`
+}
 
+// ParseNitroError extracts the NITRO error from an error returned by go-nitro.
+func ParseNitroError(err error) (*NitroError, bool) {
+	text := err.Error()
+	start := strings.Index(text, "{")
+	end := strings.LastIndex(text, "}")
+	if start < 0 || end < start {
+		return nil, false
+	}
+	var nitroErr NitroError
+	if json.Unmarshal([]byte(text[start:end+1]), &nitroErr) != nil || nitroErr.Errorcode == 0 {
+		return nil, false
+	}
+	return &nitroErr, true
 }
 
-func ListBoundPolicies(csvserverName string) ([]string, []int) {
-	ret1 := []string{}
-	ret2 := []int{}
+// listOwnedResources returns the resources carrying the ownership marker of
+// this cluster, keyed by name.
+func listOwnedResources(resourceType string) (map[string]map[string]interface{}, error) {
+	result := make(map[string]map[string]interface{})
 	client, _ := netscaler.NewNitroClientFromEnv()
-	policies, err := client.FindAllBoundResources(netscaler.Csvserver.Type(), csvserverName, netscaler.Cspolicy.Type())
+
+	resources, err := client.FindAllResources(resourceType)
 	if err != nil {
-		log.Println("No bindings for CS Vserver %s", csvserverName)
-		return ret1, ret2
+		log.Printf("Failed to find any resources of type %s", resourceType)
+		return result, err
 	}
-	for _, policy := range policies {
-		pname := policy["policyname"].(string)
-		prio, err := strconv.Atoi(policy["priority"].(string))
-		if err != nil {
-			continue
+	for _, r := range resources {
+		name, ok := r["name"].(string)
+		comment, _ := r["comment"].(string)
+		if ok && IsOwned(comment) {
+			result[name] = r
 		}
-		ret1 = append(ret1, pname)
-		ret2 = append(ret2, prio)
+	}
+	return result, nil
+}
 
+// listOwnedResourceNames returns the names of the resources carrying the
+// ownership marker of this cluster.
+func listOwnedResourceNames(resourceType string) ([]string, error) {
+	result := []string{}
+	resources, err := listOwnedResources(resourceType)
+	for name := range resources {
+		result = append(result, name)
 	}
-	sort.Ints(ret2)
-	return ret1, ret2
+	return result, err
 }
 
-func ListBoundPolicy(csvserverName string, policyName string) map[string]int {
+// ListOwnedServicegroups returns the names of the servicegroups carrying the
+// ownership marker of this cluster, servicegroups are named by
+// servicegroupname rather than name.
+func ListOwnedServicegroups() ([]string, error) {
+	result := []string{}
 	client, _ := netscaler.NewNitroClientFromEnv()
-	ret := make(map[string]int)
-	policy, err := client.FindBoundResource(netscaler.Csvserver.Type(), csvserverName, netscaler.Cspolicy.Type(), "policyname", policyName)
+	servicegroups, err := client.FindAllResources(netscaler.Servicegroup.Type())
 	if err != nil {
-		log.Println("No bindings for CS Vserver %s policy %", csvserverName, policyName)
-		return ret
+		log.Printf("Failed to find any servicegroups")
+		return result, err
+	}
+	for _, sg := range servicegroups {
+		name, ok := sg["servicegroupname"].(string)
+		comment, _ := sg["comment"].(string)
+		if ok && IsOwned(comment) {
+			result = append(result, name)
+		}
 	}
+	return result, nil
+}
+
+// ListOwnedCsActions returns the names of the content switching actions
+// carrying the ownership marker of this cluster.
+func ListOwnedCsActions() ([]string, error) {
+	return listOwnedResourceNames(netscaler.Csaction.Type())
+}
 
-	prio := policy["priority"].(string)
-	ret[policyName], _ = strconv.Atoi(prio)
+func ListOwnedContentVservers() ([]string, error) {
+	return listOwnedResourceNames(netscaler.Csvserver.Type())
+}
+
+func ListOwnedLbVservers() ([]string, error) {
+	return listOwnedResourceNames(netscaler.Lbvserver.Type())
+}
 
-	return ret
+// ListOwnedServices returns the services carrying the ownership marker of
+// this cluster, keyed by name.
+func ListOwnedServices() (map[string]map[string]interface{}, error) {
+	return listOwnedResources(netscaler.Service.Type())
+}
+
+// ListBoundPolicies returns the priorities of the content switching policies
+// bound to the content switching vserver, keyed by policy name.
+func ListBoundPolicies(csvserverName string) (map[string]int, error) {
+	ret := make(map[string]int)
+	client, _ := netscaler.NewNitroClientFromEnv()
+	policies, err := client.ListBoundResources(netscaler.Csvserver.Type(), csvserverName, netscaler.Cspolicy.Type())
+	if err != nil {
+		log.Printf("Failed to list policy bindings for CS Vserver %s, err=%s", csvserverName, err)
+		return ret, err
+	}
+	for _, policy := range policies {
+		pname := policy["policyname"].(string)
+		prio, err := strconv.Atoi(policy["priority"].(string))
+		if err != nil {
+			continue
+		}
+		ret[pname] = prio
+	}
+	return ret, nil
 }
 
 func ListPolicyAction(policyName string) string {
 	client, _ := netscaler.NewNitroClientFromEnv()
 	policy, err := client.FindResource(netscaler.Cspolicy.Type(), policyName)
 	if err != nil {
-		log.Println("No policy %s", policyName)
+		log.Printf("No policy %s", policyName)
 		return ""
 	}
 	return policy["action"].(string)
@@ -325,19 +1340,37 @@ func ListLbVserverForAction(actionName string) (string, error) {
 	client, _ := netscaler.NewNitroClientFromEnv()
 	action, err := client.FindResource(netscaler.Csaction.Type(), actionName)
 	if err != nil {
-		log.Println("No action %s", actionName)
+		log.Printf("No action %s", actionName)
 		return "", errors.New("No action " + actionName)
 	}
 	return action["targetlbvserver"].(string), nil
 }
 
+// ListBoundServiceStates returns the state of the services bound to the lb
+// vserver, keyed by service name.
+func ListBoundServiceStates(lbName string) (map[string]string, error) {
+	client, _ := netscaler.NewNitroClientFromEnv()
+	bindings, err := client.ListBoundResources(netscaler.Lbvserver.Type(), lbName, netscaler.Service.Type())
+	ret := make(map[string]string)
+	if err != nil {
+		log.Printf("Failed to list service bindings for LB Vserver %s, err=%s", lbName, err)
+		return ret, err
+	}
+	for _, b := range bindings {
+		if sname, ok := b["servicename"].(string); ok {
+			ret[sname], _ = b["curstate"].(string)
+		}
+	}
+	return ret, nil
+}
+
 func ListBoundServicesForLB(lbName string) ([]string, error) {
 	client, _ := netscaler.NewNitroClientFromEnv()
-	bindings, err := client.FindAllBoundResources(netscaler.Lbvserver.Type(), lbName, netscaler.Service.Type())
+	bindings, err := client.ListBoundResources(netscaler.Lbvserver.Type(), lbName, netscaler.Service.Type())
 	ret := []string{}
 	if err != nil {
-		log.Println("No bindings for LB Vserver %s", lbName)
-		return ret, nil
+		log.Printf("Failed to list service bindings for LB Vserver %s, err=%s", lbName, err)
+		return ret, err
 	}
 	for _, b := range bindings {
 		sname := b["servicename"].(string)
@@ -0,0 +1,125 @@
+/*
+Copyright 2016 Citrix Systems, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGeneratePolicyRule(t *testing.T) {
+	for _, tc := range []struct {
+		host  string
+		path  string
+		match string
+		rule  string
+	}{
+		{"www.example.com", "/api", pathMatchPrefix,
+			`HTTP.REQ.HOSTNAME.SERVER.SET_TEXT_MODE(IGNORECASE).EQ("www.example.com") && HTTP.REQ.URL.PATH.STARTSWITH("/api")`},
+		{"www.example.com", "/", pathMatchPrefix,
+			`HTTP.REQ.HOSTNAME.SERVER.SET_TEXT_MODE(IGNORECASE).EQ("www.example.com")`},
+		{"www.example.com", "/api", pathMatchExact,
+			`HTTP.REQ.HOSTNAME.SERVER.SET_TEXT_MODE(IGNORECASE).EQ("www.example.com") && HTTP.REQ.URL.PATH.EQ("/api")`},
+		{"www.example.com", "/api/v[0-9]+", pathMatchRegex,
+			`HTTP.REQ.HOSTNAME.SERVER.SET_TEXT_MODE(IGNORECASE).EQ("www.example.com") && HTTP.REQ.URL.PATH.REGEX_MATCH(re#/api/v[0-9]+#)`},
+		{"WWW.Example.com", "/", pathMatchPrefix,
+			`HTTP.REQ.HOSTNAME.SERVER.SET_TEXT_MODE(IGNORECASE).EQ("www.example.com")`},
+		{"*.example.com", "/api", pathMatchPrefix,
+			`HTTP.REQ.HOSTNAME.SERVER.SET_TEXT_MODE(IGNORECASE).ENDSWITH(".example.com") && HTTP.REQ.URL.PATH.STARTSWITH("/api")`},
+		{"", "/api", pathMatchPrefix, `HTTP.REQ.URL.PATH.STARTSWITH("/api")`},
+		{"", "", pathMatchPrefix, "true"},
+	} {
+		if got := GeneratePolicyRule(tc.host, tc.path, tc.match); got != tc.rule {
+			t.Errorf("GeneratePolicyRule(%q, %q, %q) = %s, want %s", tc.host, tc.path, tc.match, got, tc.rule)
+		}
+	}
+}
+
+func TestGeneratePolicyName(t *testing.T) {
+	for _, tc := range []struct {
+		ingress string
+		host    string
+		path    string
+		name    string
+	}{
+		{"web", "www.example.com", "/api", "default_web_www_example_com-_api_policy"},
+		{"web", "", "", "default_web_-nilpath_policy"},
+		{"blue", "www.example.com", "/api", "default_blue_www_example_com-_api_policy"},
+		{"web", "*.example.com", "/", "default_web__wildcard_example_com-__policy"},
+		{"web", "WWW.Example.com", "/", "default_web_www_example_com-__policy"},
+	} {
+		if got := GeneratePolicyName("default", tc.ingress, tc.host, tc.path); got != tc.name {
+			t.Errorf("GeneratePolicyName(%q, %q, %q) = %s, want %s", tc.ingress, tc.host, tc.path, got, tc.name)
+		}
+	}
+
+	// Regular expressions hold characters names can't, paths with
+	// underscores would collide with those with slashes and long paths
+	// would exceed the name limit; their path is named after a digest
+	long := "/" + strings.Repeat("a", 100)
+	for _, paths := range [][2]string{
+		{"/api/v[0-9]+/.*", "/api/v[0-9]*/.*"},
+		{"/a_b", "/a/b"},
+		{long, long + "b"},
+	} {
+		name := GeneratePolicyName("default", "web", "www.example.com", paths[0])
+		if !isValidName(name) || !strings.HasPrefix(name, "default_web_www_example_com-p_") {
+			t.Errorf("GeneratePolicyName for %q = %s", paths[0], name)
+		}
+		if other := GeneratePolicyName("default", "web", "www.example.com", paths[1]); other == name {
+			t.Errorf("paths %q and %q share the name %s", paths[0], paths[1], name)
+		}
+	}
+
+	// Names stay within the limit of NetScaler however long the ingress
+	ingress := strings.Repeat("i", 200)
+	name := GeneratePolicyName("default", ingress, "www.example.com", "/api")
+	if len(name) > maxNameLength || !isValidName(name) {
+		t.Errorf("GeneratePolicyName for a long ingress name = %s", name)
+	}
+	if other := GeneratePolicyName("default", ingress, "www.example.com", "/web"); other == name {
+		t.Errorf("paths of a long ingress name share the name %s", name)
+	}
+	if action := GenerateActionName("default", ingress, "www.example.com", "/api"); len(action) > maxNameLength {
+		t.Errorf("GenerateActionName for a long ingress name = %s", action)
+	}
+}
+
+func TestIsOwned(t *testing.T) {
+	defer func(id string) { clusterID = id }(clusterID)
+	clusterID = "a"
+	for _, tc := range []struct {
+		comment string
+		owned   bool
+	}{
+		{"kube-ingress:a:default/web", true},
+		{"kube-ingress:a:b:default/web", false},
+		{"kube-ingress:ab:default/web", false},
+		{"kube-ingress:b:default/web", false},
+		{"kube-ingress:a", false},
+		{"", false},
+	} {
+		if got := IsOwned(tc.comment); got != tc.owned {
+			t.Errorf("IsOwned(%q) = %v, want %v", tc.comment, got, tc.owned)
+		}
+	}
+
+	clusterID = "a:b"
+	if !IsOwned(OwnerComment("default/web")) {
+		t.Errorf("IsOwned(%q) = false for cluster %s", OwnerComment("default/web"), clusterID)
+	}
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2016 Citrix Systems, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/kubernetes/pkg/apis/extensions"
+)
+
+// pathMatchAnnotation chooses how the paths of the rules of an ingress match
+// the path of requests.
+const pathMatchAnnotation = "netscaler/path-match"
+
+// Path match types. A prefix path matches every request path starting with
+// it, an exact path only itself, and a regex path is a PCRE the request path
+// has to match.
+const (
+	pathMatchPrefix = "prefix"
+	pathMatchExact  = "exact"
+	pathMatchRegex  = "regex"
+)
+
+// ingressPathMatch returns the path match type the ingress asks for, prefix
+// matching by default.
+func ingressPathMatch(ing *extensions.Ingress) (string, error) {
+	value, ok := ing.Annotations[pathMatchAnnotation]
+	if !ok {
+		return pathMatchPrefix, nil
+	}
+	match := strings.ToLower(value)
+	switch match {
+	case pathMatchPrefix, pathMatchExact, pathMatchRegex:
+		return match, nil
+	}
+	return pathMatchPrefix, invalidAnnotation(pathMatchAnnotation, value)
+}
+
+// pathExpression returns the NetScaler expression matching the request path
+// against the path of a rule, or "" when the path matches every request.
+func pathExpression(path string, match string) string {
+	switch {
+	case path == "":
+		return ""
+	case match == pathMatchExact:
+		return fmt.Sprintf("HTTP.REQ.URL.PATH.EQ(%s)", quoteExpression(path))
+	case match == pathMatchRegex:
+		// Regular expressions are delimited by re#...#, ingress paths
+		// have no reason to contain a #
+		return fmt.Sprintf("HTTP.REQ.URL.PATH.REGEX_MATCH(re#%s#)", path)
+	case path == "/":
+		return ""
+	default:
+		return fmt.Sprintf("HTTP.REQ.URL.PATH.STARTSWITH(%s)", quoteExpression(path))
+	}
+}
+
+// quoteExpression quotes a string literal of a NetScaler expression.
+func quoteExpression(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	return `"` + strings.Replace(s, `"`, `\"`, -1) + `"`
+}
+
+// pathMatchRank orders match types, exact paths are more specific than
+// regular expressions, which are more specific than prefixes.
+var pathMatchRank = map[string]int{
+	pathMatchExact:  0,
+	pathMatchRegex:  1,
+	pathMatchPrefix: 2,
+}
+
+// bySpecificity orders the policies of a content vserver so that the more
+// specific of two rules is evaluated first: exact hosts before wildcard hosts
+// before rules for any host, then by match type, then longer paths first.
+// Remaining ties are broken by host and path, so that the order only depends
+// on the rules.
+type bySpecificity []*csPolicyConfig
+
+func (s bySpecificity) Len() int      { return len(s) }
+func (s bySpecificity) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s bySpecificity) Less(i, j int) bool {
+	if hi, hj := hostRank(s[i].Host), hostRank(s[j].Host); hi != hj {
+		return hi < hj
+	}
+	// The longer of two wildcards is the narrower one
+	if len(s[i].Host) != len(s[j].Host) && hostRank(s[i].Host) == 1 {
+		return len(s[i].Host) > len(s[j].Host)
+	}
+	if s[i].PathMatch != s[j].PathMatch {
+		return pathMatchRank[s[i].PathMatch] < pathMatchRank[s[j].PathMatch]
+	}
+	if len(s[i].Path) != len(s[j].Path) {
+		return len(s[i].Path) > len(s[j].Path)
+	}
+	if s[i].Host != s[j].Host {
+		return s[i].Host < s[j].Host
+	}
+	if s[i].Path != s[j].Path {
+		return s[i].Path < s[j].Path
+	}
+	return s[i].Name < s[j].Name
+}
+
+// policyPriority is the priority the policy at the given position in the
+// order of specificity is bound with.
+func policyPriority(index int) int {
+	return (index + 1) * 10
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2016 Citrix Systems, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestPolicyPriorityOrder(t *testing.T) {
+	// Listed from the most to the least specific
+	policies := []*csPolicyConfig{
+		{Name: "exact-host-exact-path", Host: "www.example.com", Path: "/api", PathMatch: pathMatchExact},
+		{Name: "exact-host-regex-path", Host: "www.example.com", Path: "/api/v[0-9]+", PathMatch: pathMatchRegex},
+		{Name: "exact-host-long-prefix", Host: "www.example.com", Path: "/api/users", PathMatch: pathMatchPrefix},
+		{Name: "exact-host-short-prefix", Host: "www.example.com", Path: "/api", PathMatch: pathMatchPrefix},
+		{Name: "exact-host-root", Host: "www.example.com", Path: "/", PathMatch: pathMatchPrefix},
+		{Name: "other-host-root", Host: "zzz.example.com", Path: "/", PathMatch: pathMatchPrefix},
+		{Name: "long-wildcard", Host: "*.api.example.com", Path: "/", PathMatch: pathMatchPrefix},
+		{Name: "wildcard-long-prefix", Host: "*.example.com", Path: "/api", PathMatch: pathMatchPrefix},
+		{Name: "wildcard-root", Host: "*.example.com", Path: "/", PathMatch: pathMatchPrefix},
+		{Name: "any-host-exact-path", Host: "", Path: "/healthz", PathMatch: pathMatchExact},
+		{Name: "any-host-prefix", Host: "", Path: "/api", PathMatch: pathMatchPrefix},
+		{Name: "any-host-all-paths", Host: "", Path: "", PathMatch: pathMatchPrefix},
+	}
+
+	// The order only depends on the rules, not on the order of the ingress
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 20; i++ {
+		shuffled := make([]*csPolicyConfig, len(policies))
+		for j, k := range r.Perm(len(policies)) {
+			shuffled[j] = policies[k]
+		}
+		sort.Sort(bySpecificity(shuffled))
+		for j := range policies {
+			if shuffled[j] != policies[j] {
+				t.Fatalf("position %d: got %s, want %s", j, shuffled[j].Name, policies[j].Name)
+			}
+		}
+	}
+}
+
+func TestPolicyPriority(t *testing.T) {
+	for _, tc := range []struct {
+		index    int
+		priority int
+	}{
+		{0, 10},
+		{1, 20},
+		{9, 100},
+	} {
+		if got := policyPriority(tc.index); got != tc.priority {
+			t.Errorf("policyPriority(%d) = %d, want %d", tc.index, got, tc.priority)
+		}
+	}
+}
+
+func TestPathExpression(t *testing.T) {
+	for _, tc := range []struct {
+		path  string
+		match string
+		expr  string
+	}{
+		{"", pathMatchPrefix, ""},
+		{"/", pathMatchPrefix, ""},
+		{"/api", pathMatchPrefix, `HTTP.REQ.URL.PATH.STARTSWITH("/api")`},
+		{"", pathMatchExact, ""},
+		{"/", pathMatchExact, `HTTP.REQ.URL.PATH.EQ("/")`},
+		{"/api", pathMatchExact, `HTTP.REQ.URL.PATH.EQ("/api")`},
+		{"/api/v[0-9]+/.*", pathMatchRegex, `HTTP.REQ.URL.PATH.REGEX_MATCH(re#/api/v[0-9]+/.*#)`},
+		{`/a"b\c`, pathMatchPrefix, `HTTP.REQ.URL.PATH.STARTSWITH("/a\"b\\c")`},
+	} {
+		if got := pathExpression(tc.path, tc.match); got != tc.expr {
+			t.Errorf("pathExpression(%q, %q) = %s, want %s", tc.path, tc.match, got, tc.expr)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+/*
+Copyright 2016 Citrix Systems, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"k8s.io/kubernetes/pkg/util/sets"
+)
+
+// rateLimitedQueue is a queue of object keys (namespace/name). A key is queued
+// at most once and is never handed to more than one worker at a time; a key
+// added while it is being processed is handed out again once the worker is
+// done with it. Failed keys are re-added with a per key exponential backoff.
+type rateLimitedQueue struct {
+	cond *sync.Cond
+
+	queue      []string
+	dirty      sets.String
+	processing sets.String
+	failures   map[string]int
+
+	baseDelay    time.Duration
+	maxDelay     time.Duration
+	shuttingDown bool
+}
+
+func newRateLimitedQueue(baseDelay time.Duration, maxDelay time.Duration) *rateLimitedQueue {
+	return &rateLimitedQueue{
+		cond:       sync.NewCond(&sync.Mutex{}),
+		dirty:      sets.NewString(),
+		processing: sets.NewString(),
+		failures:   make(map[string]int),
+		baseDelay:  baseDelay,
+		maxDelay:   maxDelay,
+	}
+}
+
+// Add queues the key unless it is already waiting to be processed.
+func (q *rateLimitedQueue) Add(key string) {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	if q.shuttingDown || q.dirty.Has(key) {
+		return
+	}
+	q.dirty.Insert(key)
+	if q.processing.Has(key) {
+		return
+	}
+	q.queue = append(q.queue, key)
+	q.cond.Signal()
+}
+
+// AddRateLimited queues the key once its backoff has expired. The backoff
+// doubles with every failure of the key until Forget is called.
+func (q *rateLimitedQueue) AddRateLimited(key string) {
+	q.cond.L.Lock()
+	failures := q.failures[key]
+	q.failures[key] = failures + 1
+	q.cond.L.Unlock()
+
+	time.AfterFunc(q.backoff(failures), func() { q.Add(key) })
+}
+
+// backoff returns the delay before a key is retried after the given number of
+// earlier failures.
+func (q *rateLimitedQueue) backoff(failures int) time.Duration {
+	delay := q.baseDelay
+	for i := 0; i < failures && delay < q.maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > q.maxDelay {
+		delay = q.maxDelay
+	}
+	return delay
+}
+
+// Forget resets the backoff of the key.
+func (q *rateLimitedQueue) Forget(key string) {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	delete(q.failures, key)
+}
+
+// NumRequeues returns how often the key has been requeued after a failure.
+func (q *rateLimitedQueue) NumRequeues(key string) int {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	return q.failures[key]
+}
+
+// Get blocks until a key is available. It returns false once the queue is
+// shut down.
+func (q *rateLimitedQueue) Get() (string, bool) {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	for len(q.queue) == 0 && !q.shuttingDown {
+		q.cond.Wait()
+	}
+	if len(q.queue) == 0 {
+		return "", false
+	}
+	key := q.queue[0]
+	q.queue = q.queue[1:]
+	q.processing.Insert(key)
+	q.dirty.Delete(key)
+	return key, true
+}
+
+// Done marks the key as processed. It has to be called for every key returned
+// by Get.
+func (q *rateLimitedQueue) Done(key string) {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	q.processing.Delete(key)
+	if q.dirty.Has(key) {
+		q.queue = append(q.queue, key)
+		q.cond.Signal()
+	}
+}
+
+// Len returns the number of keys waiting to be processed.
+func (q *rateLimitedQueue) Len() int {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	return len(q.queue)
+}
+
+// ShutDown makes Get return false once the queue is drained.
+func (q *rateLimitedQueue) ShutDown() {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	q.shuttingDown = true
+	q.cond.Broadcast()
+}
+
+// processQueue hands keys from the queue to syncFn until the queue is shut
+// down. Keys whose sync fails are retried with backoff; after maxRetries
+// failures in a row the key is dropped and logged as a dead letter, the next
+// resync picks it up again.
+func processQueue(q *rateLimitedQueue, syncFn func(string) error, maxRetries int) {
+	for {
+		key, ok := q.Get()
+		if !ok {
+			return
+		}
+		err := syncFn(key)
+		switch {
+		case err == nil:
+			q.Forget(key)
+		case q.NumRequeues(key) < maxRetries:
+			log.Printf("Failed to sync %s, retrying: %v", key, err)
+			q.AddRateLimited(key)
+		default:
+			log.Printf("[dead-letter] Giving up on %s after %d retries: %v", key, maxRetries, err)
+			q.Forget(key)
+		}
+		q.Done(key)
+	}
+}
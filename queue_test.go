@@ -0,0 +1,177 @@
+/*
+Copyright 2016 Citrix Systems, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestQueueOrder(t *testing.T) {
+	// Steps are "add <key>", "get" or "done <key>"
+	tests := []struct {
+		name  string
+		steps []string
+		want  []string
+		len   int
+	}{
+		{
+			name:  "added keys are handed out in order",
+			steps: []string{"add a", "add b", "get", "get"},
+			want:  []string{"a", "b"},
+		},
+		{
+			name:  "waiting key is added once",
+			steps: []string{"add a", "add b", "add a", "get", "get"},
+			want:  []string{"a", "b"},
+		},
+		{
+			name:  "key added while processed waits for done",
+			steps: []string{"add a", "get", "add a"},
+			want:  []string{"a"},
+		},
+		{
+			name:  "key added while processed is handed out again after done",
+			steps: []string{"add a", "get", "add a", "add a", "done a", "get"},
+			want:  []string{"a", "a"},
+		},
+		{
+			name:  "processed key is not handed out again",
+			steps: []string{"add a", "get", "done a"},
+			want:  []string{"a"},
+		},
+		{
+			name:  "key re-added after done is queued behind others",
+			steps: []string{"add a", "get", "add b", "add a", "done a"},
+			want:  []string{"a"},
+			len:   2,
+		},
+	}
+
+	for _, tt := range tests {
+		q := newRateLimitedQueue(time.Millisecond, time.Millisecond)
+		got := []string{}
+		for _, step := range tt.steps {
+			fields := strings.Fields(step)
+			switch fields[0] {
+			case "add":
+				q.Add(fields[1])
+			case "get":
+				key, ok := q.Get()
+				if !ok {
+					t.Fatalf("%s: Get() returned no key", tt.name)
+				}
+				got = append(got, key)
+			case "done":
+				q.Done(fields[1])
+			}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got keys %v, want %v", tt.name, got, tt.want)
+		}
+		if q.Len() != tt.len {
+			t.Errorf("%s: Len() = %d, want %d", tt.name, q.Len(), tt.len)
+		}
+	}
+}
+
+func TestQueueBackoff(t *testing.T) {
+	q := newRateLimitedQueue(time.Second, 5*time.Minute)
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, time.Second},
+		{1, 2 * time.Second},
+		{4, 16 * time.Second},
+		{8, 256 * time.Second},
+		{9, 5 * time.Minute},
+		{100, 5 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		if got := q.backoff(tt.failures); got != tt.want {
+			t.Errorf("backoff(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestQueueAddRateLimited(t *testing.T) {
+	q := newRateLimitedQueue(time.Millisecond, time.Millisecond)
+	q.AddRateLimited("a")
+	q.AddRateLimited("a")
+	if got := q.NumRequeues("a"); got != 2 {
+		t.Errorf("NumRequeues() = %d, want 2", got)
+	}
+	if key, ok := q.Get(); !ok || key != "a" {
+		t.Errorf("Get() = %q, %v, want \"a\", true", key, ok)
+	}
+	q.Forget("a")
+	if got := q.NumRequeues("a"); got != 0 {
+		t.Errorf("NumRequeues() after Forget = %d, want 0", got)
+	}
+}
+
+func TestProcessQueue(t *testing.T) {
+	tests := []struct {
+		name      string
+		failures  int
+		wantCalls int
+	}{
+		{name: "success", failures: 0, wantCalls: 1},
+		{name: "retried until success", failures: 2, wantCalls: 3},
+		{name: "dead-lettered after maxRetries", failures: 100, wantCalls: 4},
+	}
+
+	for _, tt := range tests {
+		q := newRateLimitedQueue(time.Millisecond, time.Millisecond)
+		var mu sync.Mutex
+		calls := 0
+		syncFn := func(key string) error {
+			mu.Lock()
+			defer mu.Unlock()
+			calls++
+			if calls <= tt.failures {
+				return errors.New("sync failed")
+			}
+			return nil
+		}
+		q.Add("a")
+		done := make(chan struct{})
+		go func() {
+			processQueue(q, syncFn, 3)
+			close(done)
+		}()
+		// Retries are queued after a millisecond, give them time to run
+		time.Sleep(100 * time.Millisecond)
+		q.ShutDown()
+		<-done
+
+		mu.Lock()
+		if calls != tt.wantCalls {
+			t.Errorf("%s: synced %d times, want %d", tt.name, calls, tt.wantCalls)
+		}
+		mu.Unlock()
+		if got := q.NumRequeues("a"); got != 0 {
+			t.Errorf("%s: NumRequeues() = %d, want 0", tt.name, got)
+		}
+	}
+}
@@ -0,0 +1,1600 @@
+/*
+Copyright 2016 Citrix Systems, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	"k8s.io/kubernetes/pkg/client/cache"
+	client "k8s.io/kubernetes/pkg/client/unversioned"
+	utilerrors "k8s.io/kubernetes/pkg/util/errors"
+	"k8s.io/kubernetes/pkg/util/intstr"
+	"k8s.io/kubernetes/pkg/util/sets"
+)
+
+// The desired NetScaler configuration is built from the informer stores on
+// every reconcile and compared against what the NetScaler reports, so that the
+// controller does not need to remember what it configured earlier.
+
+type serviceConfig struct {
+	Name     string
+	IP       string
+	Port     int
+	Protocol string
+	// Owner is the key of the ingress the service is marked with, the
+	// lowest of the ingresses sharing it so the marker is stable
+	Owner string
+	// Monitor is the name of the monitor probing the service, if any
+	Monitor string
+}
+
+type lbVserverConfig struct {
+	Name        string
+	Namespace   string
+	ServiceName string
+	Protocol    string
+	// Services has an entry for every endpoint. In servicegroup mode the
+	// endpoints become members of Servicegroup instead of services.
+	Services     map[string]*serviceConfig
+	Servicegroup string
+	// Owner is the key of the ingress the lb vserver is marked with, the
+	// lowest of the ingresses sharing it so the marker is stable
+	Owner string
+	// DrainTimeout is the longest drain timeout of the ingresses using
+	// the lb vserver
+	DrainTimeout time.Duration
+	// Settings are taken from the oldest ingress using the lb vserver that
+	// has settings annotations
+	Settings *lbVserverSettings
+}
+
+type csPolicyConfig struct {
+	Name      string
+	Action    string
+	Rule      string
+	LbName    string
+	Owner     string
+	Host      string
+	Path      string
+	PathMatch string
+}
+
+type certKeyConfig struct {
+	Name     string
+	CertFile string
+	KeyFile  string
+	Cert     []byte
+	Key      []byte
+}
+
+type certKeyBinding struct {
+	Name string
+	SNI  bool
+}
+
+type csVserverConfig struct {
+	Name     string
+	IP       string
+	Port     int
+	Protocol string
+	Policies []*csPolicyConfig
+	// DefaultLb receives the requests no policy matches
+	DefaultLb string
+	Owner     string
+	// CertKeys are bound to SSL vservers only. The first one is also the
+	// certificate for clients that don't send SNI.
+	CertKeys []string
+	SNI      bool
+}
+
+type nsConfig struct {
+	CsVservers    map[string]*csVserverConfig
+	LbVservers    map[string]*lbVserverConfig
+	Services      sets.String
+	Servicegroups sets.String
+	CertKeys      map[string]*certKeyConfig
+	Monitors      map[string]*monitorConfig
+	// Listeners maps each VIP:port to the key of the ingress using it
+	Listeners map[string]string
+	// Problems found while building the configuration, by ingress key
+	Problems map[string][]*ingressError
+}
+
+// ingressError is a problem with an ingress that is reported as an Event on
+// the ingress.
+type ingressError struct {
+	Reason  string
+	Message string
+}
+
+func (e *ingressError) Error() string {
+	return e.Message
+}
+
+func (cfg *nsConfig) addProblem(ingressKey string, err error) {
+	ingErr, ok := err.(*ingressError)
+	if !ok {
+		ingErr = &ingressError{Reason: reasonInvalidIngress, Message: err.Error()}
+	}
+	cfg.Problems[ingressKey] = append(cfg.Problems[ingressKey], ingErr)
+}
+
+// invalidAnnotation reports an annotation of an ingress with a value the
+// controller does not understand.
+func invalidAnnotation(name string, value string) error {
+	return &ingressError{
+		Reason:  reasonInvalidAnnotation,
+		Message: fmt.Sprintf("Invalid value %q for annotation %s", value, name),
+	}
+}
+
+func claim(owner *string, ingressKey string) {
+	if *owner == "" || ingressKey < *owner {
+		*owner = ingressKey
+	}
+}
+
+func newNsConfig() *nsConfig {
+	return &nsConfig{
+		CsVservers:    make(map[string]*csVserverConfig),
+		LbVservers:    make(map[string]*lbVserverConfig),
+		Services:      sets.NewString(),
+		Servicegroups: sets.NewString(),
+		CertKeys:      make(map[string]*certKeyConfig),
+		Monitors:      make(map[string]*monitorConfig),
+		Listeners:     make(map[string]string),
+		Problems:      make(map[string][]*ingressError),
+	}
+}
+
+// claimListener reserves the VIP and port of a content vserver for the
+// ingress, a VIP and port already used by another ingress is an error.
+func (cfg *nsConfig) claimListener(ip string, port int, ingressKey string) error {
+	listener := fmt.Sprintf("%s:%d", ip, port)
+	if owner, ok := cfg.Listeners[listener]; ok && owner != ingressKey {
+		return &ingressError{
+			Reason:  reasonVIPConflict,
+			Message: fmt.Sprintf("%s of ingress %s is already used by ingress %s", listener, ingressKey, owner),
+		}
+	}
+	cfg.Listeners[listener] = ingressKey
+	return nil
+}
+
+// addMonitor adds the monitor for a backend service and returns its name.
+func (cfg *nsConfig) addMonitor(namespace string, serviceName string, monitor *monitorConfig) string {
+	name := GenerateMonitorName(namespace, serviceName, monitor.settings())
+	if _, ok := cfg.Monitors[name]; !ok {
+		m := *monitor
+		m.Name = name
+		cfg.Monitors[name] = &m
+	}
+	return name
+}
+
+func (csv *csVserverConfig) certKeyBindings() []certKeyBinding {
+	bindings := []certKeyBinding{}
+	for i, name := range csv.CertKeys {
+		if i == 0 {
+			bindings = append(bindings, certKeyBinding{Name: name})
+		}
+		if csv.SNI {
+			bindings = append(bindings, certKeyBinding{Name: name, SNI: true})
+		}
+	}
+	return bindings
+}
+
+type endpointAddress struct {
+	IP   string
+	Port int
+	// Pod is the store key of the pod behind the address, if known
+	Pod string
+}
+
+// Pass ports=nil for all ports.
+func endpointAddresses(endpoints *api.Endpoints, ports sets.String) []endpointAddress {
+	list := []endpointAddress{}
+	for i := range endpoints.Subsets {
+		ss := &endpoints.Subsets[i]
+		for i := range ss.Ports {
+			port := &ss.Ports[i]
+			if ports == nil || ports.Has(port.Name) {
+				for i := range ss.Addresses {
+					addr := &ss.Addresses[i]
+					ep := endpointAddress{IP: addr.IP, Port: port.Port}
+					if ref := addr.TargetRef; ref != nil && ref.Kind == "Pod" {
+						ep.Pod = ref.Namespace + "/" + ref.Name
+					}
+					list = append(list, ep)
+				}
+			}
+		}
+	}
+	return list
+}
+
+// backendEndpointsKey returns the store key of the endpoints backing a service
+// referenced by an ingress. Ingresses can only refer to services in their own
+// namespace.
+func backendEndpointsKey(ing *extensions.Ingress, serviceName string) string {
+	return ing.Namespace + "/" + serviceName
+}
+
+// ingressClassAnnotation picks the controller responsible for an ingress.
+const ingressClassAnnotation = "kubernetes.io/ingress.class"
+
+// ingressClassFilter selects the ingresses this controller configures.
+type ingressClassFilter struct {
+	Class          string
+	ClaimClassless bool
+}
+
+func (f ingressClassFilter) Matches(ing *extensions.Ingress) bool {
+	class, ok := ing.Annotations[ingressClassAnnotation]
+	if !ok || class == "" {
+		return f.ClaimClassless
+	}
+	return class == f.Class
+}
+
+type reconciler struct {
+	kubeClient   *client.Client
+	ingLister    StoreToIngressLister
+	svcLister    cache.StoreToServiceLister
+	epLister     cache.StoreToEndpointsLister
+	secretLister StoreToSecretLister
+	podLister    cache.StoreToPodLister
+	classFilter  ingressClassFilter
+	vips         *vipAllocator
+	recorder     *eventRecorder
+	// servicegroups selects servicegroup mode
+	servicegroups bool
+	drainTimeout  time.Duration
+	drains        *drainTracker
+	// defaultBackend serves ingresses without a backend of their own, if
+	// set
+	defaultBackend *defaultBackend
+
+	// Ingresses are synced concurrently while holding mu for reading.
+	// Garbage collection holds it for writing so that it never removes
+	// objects a concurrent sync has just created.
+	mu sync.RWMutex
+	// lbLocks serializes the syncs of lb vservers shared by ingresses
+	// synced concurrently, which would otherwise race adding and binding
+	// the same services and monitors.
+	lbLocks *keyedMutex
+	// configs caches the desired configuration between syncs
+	configs configCache
+}
+
+// configCache holds the desired configuration until it is invalidated by a
+// change of the objects it is built from.
+type configCache struct {
+	// build serializes rebuilds, so concurrent syncs share one
+	build      sync.Mutex
+	mu         sync.Mutex
+	generation uint64
+	built      uint64
+	cfg        *nsConfig
+}
+
+// Invalidate marks the cached configuration as outdated.
+func (c *configCache) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+}
+
+// Get returns the cached configuration, calling build first when it is
+// outdated. A configuration invalidated while it is built is returned but not
+// cached.
+func (c *configCache) Get(build func() *nsConfig) *nsConfig {
+	c.build.Lock()
+	defer c.build.Unlock()
+	c.mu.Lock()
+	cfg, generation := c.cfg, c.generation
+	fresh := cfg != nil && c.built == generation
+	c.mu.Unlock()
+	if fresh {
+		return cfg
+	}
+
+	cfg = build()
+	c.mu.Lock()
+	if c.generation == generation {
+		c.cfg, c.built = cfg, generation
+	}
+	c.mu.Unlock()
+	return cfg
+}
+
+// keyedMutex is a mutex per key.
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+func newKeyedMutex() *keyedMutex {
+	return &keyedMutex{locks: make(map[string]*sync.Mutex)}
+}
+
+// Lock locks the mutex of the key and returns the function unlocking it.
+func (k *keyedMutex) Lock(key string) func() {
+	k.mu.Lock()
+	lock, ok := k.locks[key]
+	if !ok {
+		lock = &sync.Mutex{}
+		k.locks[key] = lock
+	}
+	k.mu.Unlock()
+	lock.Lock()
+	return lock.Unlock
+}
+
+func newReconciler(kubeClient *client.Client, ingLister StoreToIngressLister, svcLister cache.StoreToServiceLister, epLister cache.StoreToEndpointsLister,
+	secretLister StoreToSecretLister, podLister cache.StoreToPodLister, classFilter ingressClassFilter, vips *vipAllocator,
+	servicegroups bool, drainTimeout time.Duration, defaultBackend *defaultBackend) *reconciler {
+	return &reconciler{
+		kubeClient:     kubeClient,
+		ingLister:      ingLister,
+		svcLister:      svcLister,
+		epLister:       epLister,
+		secretLister:   secretLister,
+		podLister:      podLister,
+		classFilter:    classFilter,
+		vips:           vips,
+		recorder:       newEventRecorder(kubeClient),
+		servicegroups:  servicegroups,
+		drainTimeout:   drainTimeout,
+		drains:         newDrainTracker(),
+		defaultBackend: defaultBackend,
+		lbLocks:        newKeyedMutex(),
+	}
+}
+
+// byCreation orders ingresses oldest first, so that the older of two
+// ingresses asking for the same VIP and port keeps it.
+type byCreation []*extensions.Ingress
+
+func (s byCreation) Len() int      { return len(s) }
+func (s byCreation) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byCreation) Less(i, j int) bool {
+	ti, tj := s[i].CreationTimestamp, s[j].CreationTimestamp
+	if !ti.Equal(tj) {
+		return ti.Before(tj)
+	}
+	if s[i].Namespace != s[j].Namespace {
+		return s[i].Namespace < s[j].Namespace
+	}
+	return s[i].Name < s[j].Name
+}
+
+// ingresses returns the ingresses in the store that are meant for this
+// controller.
+func (r *reconciler) ingresses() []*extensions.Ingress {
+	ingresses := []*extensions.Ingress{}
+	for _, obj := range r.ingLister.List() {
+		ing := obj.(*extensions.Ingress)
+		if r.classFilter.Matches(ing) {
+			ingresses = append(ingresses, ing)
+		}
+	}
+	sort.Sort(byCreation(ingresses))
+	return ingresses
+}
+
+// ingressVIP returns the VIP of the ingress, either requested through the
+// publicIP annotation or allocated from the VIP pools.
+func (r *reconciler) ingressVIP(ing *extensions.Ingress, ingressKey string) (string, error) {
+	if publicIP, ok := ing.Annotations["publicIP"]; ok {
+		if owner, ok := r.vips.Owner(publicIP); ok && owner != ingressKey {
+			return "", &ingressError{
+				Reason:  reasonVIPConflict,
+				Message: fmt.Sprintf("publicIP %s of ingress %s is allocated to ingress %s", publicIP, ingressKey, owner),
+			}
+		}
+		return publicIP, nil
+	}
+	if ip, ok := r.vips.Lookup(ingressKey); ok {
+		return ip, nil
+	}
+	return "", &ingressError{
+		Reason:  reasonMissingPublicIP,
+		Message: "Failed to retrieve annotation publicIP for ingress " + ing.Name,
+	}
+}
+
+// needsVIP reports whether the ingress with the given key exists, is meant
+// for this controller and has no publicIP annotation.
+func (r *reconciler) needsVIP(ingressKey string) (bool, error) {
+	obj, exists, err := r.ingLister.GetByKey(ingressKey)
+	if err != nil || !exists {
+		return false, err
+	}
+	ing := obj.(*extensions.Ingress)
+	_, hasPublicIP := ing.Annotations["publicIP"]
+	return r.classFilter.Matches(ing) && !hasPublicIP, nil
+}
+
+// assignVIP allocates a VIP from the pools to the ingress with the given key
+// when it needs one, and releases it once it doesn't.
+func (r *reconciler) assignVIP(ingressKey string) error {
+	if !r.vips.Enabled() {
+		return nil
+	}
+	needed, err := r.needsVIP(ingressKey)
+	if err != nil {
+		return err
+	}
+	// The desired configuration depends on the allocations, it is only
+	// invalidated when they change
+	if _, ok := r.vips.Lookup(ingressKey); ok == needed {
+		return nil
+	}
+	defer r.configs.Invalidate()
+	if !needed {
+		return r.vips.Release(ingressKey)
+	}
+	requested := make(map[string]bool)
+	for _, ing := range r.ingresses() {
+		if publicIP, ok := ing.Annotations["publicIP"]; ok {
+			requested[publicIP] = true
+		}
+	}
+	_, err = r.vips.Allocate(ingressKey, requested)
+	return err
+}
+
+// ingressKeys returns the keys of the ingresses meant for this controller.
+func (r *reconciler) ingressKeys() []string {
+	keys := []string{}
+	for _, ing := range r.ingresses() {
+		key, _ := cache.MetaNamespaceKeyFunc(ing)
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// secretCertKey returns the certkey for a kubernetes.io/tls secret. The
+// certificate and key files are named after their content, so that a changed
+// secret results in new files the certkey is updated to.
+func (r *reconciler) secretCertKey(namespace string, secretName string) (*certKeyConfig, error) {
+	key := namespace + "/" + secretName
+	obj, exists, err := r.secretLister.GetByKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("secret %s not found", key)
+	}
+	secret := obj.(*api.Secret)
+	cert, ok := secret.Data[api.TLSCertKey]
+	if !ok {
+		return nil, fmt.Errorf("secret %s has no %s", key, api.TLSCertKey)
+	}
+	privateKey, ok := secret.Data[api.TLSPrivateKeyKey]
+	if !ok {
+		return nil, fmt.Errorf("secret %s has no %s", key, api.TLSPrivateKeyKey)
+	}
+
+	hash := sha1.New()
+	hash.Write(cert)
+	hash.Write(privateKey)
+	suffix := hex.EncodeToString(hash.Sum(nil))[:12]
+	name := GenerateCertKeyName(namespace, secretName)
+	return &certKeyConfig{
+		Name:     name,
+		CertFile: name + "_" + suffix + ".crt",
+		KeyFile:  name + "_" + suffix + ".key",
+		Cert:     cert,
+		Key:      privateKey,
+	}, nil
+}
+
+// backendServicePort returns the service port an ingress backend refers to.
+// The servicePort of the backend may either be the port number or the name of
+// a port of the service.
+func (r *reconciler) backendServicePort(namespace string, backend extensions.IngressBackend) (*api.ServicePort, error) {
+	key := namespace + "/" + backend.ServiceName
+	obj, exists, err := r.svcLister.GetByKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("service %s not found", key)
+	}
+	svc := obj.(*api.Service)
+
+	for i := range svc.Spec.Ports {
+		sp := &svc.Spec.Ports[i]
+		switch backend.ServicePort.Type {
+		case intstr.Int:
+			if sp.Port == backend.ServicePort.IntValue() {
+				return sp, nil
+			}
+		case intstr.String:
+			if sp.Name == backend.ServicePort.StrVal {
+				return sp, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("service %s has no port %s", key, backend.ServicePort.String())
+}
+
+// backendEndpoints returns the endpoint addresses for the service port an
+// ingress backend refers to. Endpoint ports carry the name of the service port
+// they were derived from.
+func (r *reconciler) backendEndpoints(namespace string, backend extensions.IngressBackend) ([]endpointAddress, error) {
+	servicePort, err := r.backendServicePort(namespace, backend)
+	if err != nil {
+		return nil, err
+	}
+
+	key := namespace + "/" + backend.ServiceName
+	obj, exists, err := r.epLister.GetByKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("endpoints %s not found", key)
+	}
+	return endpointAddresses(obj.(*api.Endpoints), sets.NewString(servicePort.Name)), nil
+}
+
+// backendOptions are the settings an ingress applies to its backends.
+type backendOptions struct {
+	Monitor         *monitorConfig
+	ExplicitMonitor bool
+	DrainTimeout    time.Duration
+	Settings        *lbVserverSettings
+}
+
+// addBackendConfig adds the lb vserver load balancing the endpoints of the
+// backend, a service in the given namespace, and returns its name.
+func (r *reconciler) addBackendConfig(cfg *nsConfig, ingKey string, namespace string, backend extensions.IngressBackend, opts *backendOptions) string {
+	serviceName := backend.ServiceName
+	// Backends referring to the same service port by name and by number
+	// share the lb vserver
+	port := backend.ServicePort.String()
+	if servicePort, err := r.backendServicePort(namespace, backend); err == nil {
+		port = strconv.Itoa(servicePort.Port)
+	}
+	lbName := GenerateLbName(namespace, serviceName, port)
+	lbvserver, ok := cfg.LbVservers[lbName]
+	if !ok {
+		lbvserver = &lbVserverConfig{
+			Name:        lbName,
+			Namespace:   namespace,
+			ServiceName: serviceName,
+			Protocol:    "HTTP",
+			Services:    make(map[string]*serviceConfig),
+		}
+		if r.servicegroups {
+			lbvserver.Servicegroup = GenerateServicegroupName(namespace, serviceName, port)
+			cfg.Servicegroups.Insert(lbvserver.Servicegroup)
+		}
+		cfg.LbVservers[lbName] = lbvserver
+	}
+	claim(&lbvserver.Owner, ingKey)
+	if opts.DrainTimeout > lbvserver.DrainTimeout {
+		lbvserver.DrainTimeout = opts.DrainTimeout
+	}
+	if lbvserver.Settings == nil {
+		lbvserver.Settings = opts.Settings
+	}
+
+	endpoints, err := r.backendEndpoints(namespace, backend)
+	if err != nil {
+		cfg.addProblem(ingKey, &ingressError{
+			Reason:  reasonEndpointLookupFailed,
+			Message: fmt.Sprintf("Failed to retrieve endpoints for service %s/%s: %v", namespace, serviceName, err),
+		})
+		return lbName
+	}
+	for _, ep := range endpoints {
+		sname := GenerateServiceName(namespace, serviceName, ep.IP, ep.Port)
+		svc, ok := lbvserver.Services[sname]
+		if !ok {
+			svc = &serviceConfig{
+				Name:     sname,
+				IP:       ep.IP,
+				Port:     ep.Port,
+				Protocol: "HTTP",
+			}
+			svcMonitor := opts.Monitor
+			if !opts.ExplicitMonitor {
+				svcMonitor = r.probeMonitor(ep)
+			}
+			if svcMonitor != nil {
+				svc.Monitor = cfg.addMonitor(namespace, serviceName, svcMonitor)
+			}
+			lbvserver.Services[sname] = svc
+		}
+		claim(&svc.Owner, ingKey)
+		if lbvserver.Servicegroup == "" {
+			cfg.Services.Insert(sname)
+		}
+	}
+	return lbName
+}
+
+func (r *reconciler) addIngressConfig(cfg *nsConfig, ing *extensions.Ingress) error {
+	protocol, ok := ing.Annotations["protocol"]
+	if !ok {
+		protocol = "HTTP"
+	}
+	port, ok := ing.Annotations["port"]
+	if !ok {
+		port = "80"
+	}
+	intPort, err := strconv.Atoi(port)
+	if err != nil {
+		return errors.New("Failed to parse port annotation for ingress " + ing.Name)
+	}
+	sslPort, ok := ing.Annotations["sslPort"]
+	if !ok {
+		sslPort = "443"
+	}
+	intSslPort, err := strconv.Atoi(sslPort)
+	if err != nil {
+		return errors.New("Failed to parse sslPort annotation for ingress " + ing.Name)
+	}
+	ingKey, err := cache.MetaNamespaceKeyFunc(ing)
+	if err != nil {
+		return err
+	}
+	publicIP, err := r.ingressVIP(ing, ingKey)
+	if err != nil {
+		return err
+	}
+	if err := cfg.claimListener(publicIP, intPort, ingKey); err != nil {
+		return err
+	}
+	if len(ing.Spec.TLS) > 0 {
+		if err := cfg.claimListener(publicIP, intSslPort, ingKey); err != nil {
+			return err
+		}
+	}
+	monitor, explicitMonitor, err := ingressMonitor(ing)
+	if err != nil {
+		// Fall back to the probes of the pods
+		cfg.addProblem(ingKey, err)
+	}
+	drainTimeout, err := ingressDrainTimeout(ing, r.drainTimeout)
+	if err != nil {
+		cfg.addProblem(ingKey, err)
+	}
+	lbSettings, errs := ingressLbSettings(ing)
+	for _, err := range errs {
+		cfg.addProblem(ingKey, err)
+	}
+	pathMatch, err := ingressPathMatch(ing)
+	if err != nil {
+		cfg.addProblem(ingKey, err)
+	}
+	opts := &backendOptions{
+		Monitor:         monitor,
+		ExplicitMonitor: explicitMonitor,
+		DrainTimeout:    drainTimeout,
+		Settings:        lbSettings,
+	}
+	csv := &csVserverConfig{
+		Name:     GenerateCsVserverName(ing.Namespace, ing.Name),
+		IP:       publicIP,
+		Port:     intPort,
+		Protocol: protocol,
+		Owner:    ingKey,
+	}
+	for _, rule := range ing.Spec.Rules {
+		if rule.HTTP == nil {
+			continue
+		}
+		host := rule.Host
+		for _, path := range rule.HTTP.Paths {
+			if pathMatch == pathMatchRegex && strings.Contains(path.Path, "#") {
+				cfg.addProblem(ingKey, &ingressError{
+					Reason:  reasonInvalidIngress,
+					Message: fmt.Sprintf("Path %q cannot be used as a regular expression, it contains a #", path.Path),
+				})
+				continue
+			}
+			lbName := r.addBackendConfig(cfg, ingKey, ing.Namespace, path.Backend, opts)
+			csv.Policies = append(csv.Policies, &csPolicyConfig{
+				Name:      GeneratePolicyName(ing.Namespace, ing.Name, host, path.Path),
+				Host:      host,
+				Action:    GenerateActionName(ing.Namespace, ing.Name, host, path.Path),
+				Rule:      GeneratePolicyRule(host, path.Path, pathMatch),
+				LbName:    lbName,
+				Owner:     ingKey,
+				Path:      path.Path,
+				PathMatch: pathMatch,
+			})
+		}
+	}
+	switch {
+	case ing.Spec.Backend != nil:
+		csv.DefaultLb = r.addBackendConfig(cfg, ingKey, ing.Namespace, *ing.Spec.Backend, opts)
+	case r.defaultBackend != nil:
+		csv.DefaultLb = r.addBackendConfig(cfg, ingKey, r.defaultBackend.Namespace, r.defaultBackend.Backend, &backendOptions{DrainTimeout: r.drainTimeout})
+	}
+	// Policies are prioritized in this order, so more specific rules take
+	// precedence
+	sort.Sort(bySpecificity(csv.Policies))
+	cfg.CsVservers[csv.Name] = csv
+
+	if len(ing.Spec.TLS) == 0 {
+		return nil
+	}
+	sslCsv := &csVserverConfig{
+		Name:      GenerateSslCsVserverName(ing.Namespace, ing.Name),
+		IP:        publicIP,
+		Port:      intSslPort,
+		Protocol:  "SSL",
+		Policies:  csv.Policies,
+		DefaultLb: csv.DefaultLb,
+		Owner:     ingKey,
+	}
+	certKeys := sets.NewString()
+	for _, tls := range ing.Spec.TLS {
+		if tls.SecretName == "" {
+			continue
+		}
+		certkey, err := r.secretCertKey(ing.Namespace, tls.SecretName)
+		if err != nil {
+			cfg.addProblem(ingKey, &ingressError{
+				Reason:  reasonSecretLookupFailed,
+				Message: fmt.Sprintf("Failed to retrieve TLS certificate: %v", err),
+			})
+			continue
+		}
+		cfg.CertKeys[certkey.Name] = certkey
+		if !certKeys.Has(certkey.Name) {
+			certKeys.Insert(certkey.Name)
+			sslCsv.CertKeys = append(sslCsv.CertKeys, certkey.Name)
+		}
+	}
+	// Hosts with different certificates need SNI to pick theirs
+	sslCsv.SNI = len(sslCsv.CertKeys) > 1
+	cfg.CsVservers[sslCsv.Name] = sslCsv
+	return nil
+}
+
+// desiredConfig returns the NetScaler configuration for every ingress in the
+// store that is meant for this controller.
+func (r *reconciler) desiredConfig() *nsConfig {
+	return r.configs.Get(r.buildConfig)
+}
+
+// buildConfig builds the desired configuration. Problems are only recorded,
+// they are logged and reported by the sync of their ingress.
+func (r *reconciler) buildConfig() *nsConfig {
+	cfg := newNsConfig()
+	ingresses := 0
+	for _, ing := range r.ingresses() {
+		if err := r.addIngressConfig(cfg, ing); err != nil {
+			key, _ := cache.MetaNamespaceKeyFunc(ing)
+			cfg.addProblem(key, err)
+			continue
+		}
+		ingresses++
+	}
+	managedIngresses.Set(float64(ingresses))
+	endpoints := sets.NewString()
+	for _, lbvserver := range cfg.LbVservers {
+		endpoints.Insert(sets.StringKeySet(lbvserver.Services).List()...)
+	}
+	managedEndpoints.Set(float64(endpoints.Len()))
+	return cfg
+}
+
+// ingressesForService returns the keys of the ingresses that route to the
+// service with the given namespace and name.
+func (r *reconciler) ingressesForService(namespace string, name string) []string {
+	keys := []string{}
+	svcKey := namespace + "/" + name
+	for _, ing := range r.ingresses() {
+		for _, rule := range ing.Spec.Rules {
+			if rule.HTTP == nil {
+				continue
+			}
+			for _, path := range rule.HTTP.Paths {
+				if backendEndpointsKey(ing, path.Backend.ServiceName) == svcKey {
+					key, _ := cache.MetaNamespaceKeyFunc(ing)
+					keys = append(keys, key)
+				}
+			}
+		}
+		switch {
+		case ing.Spec.Backend != nil:
+			if backendEndpointsKey(ing, ing.Spec.Backend.ServiceName) == svcKey {
+				key, _ := cache.MetaNamespaceKeyFunc(ing)
+				keys = append(keys, key)
+			}
+		case r.defaultBackend != nil:
+			if r.defaultBackend.Namespace == namespace && r.defaultBackend.Backend.ServiceName == name {
+				key, _ := cache.MetaNamespaceKeyFunc(ing)
+				keys = append(keys, key)
+			}
+		}
+	}
+	return sets.NewString(keys...).List()
+}
+
+// ingressesForSecret returns the keys of the ingresses that terminate TLS with
+// the secret with the given namespace and name.
+func (r *reconciler) ingressesForSecret(namespace string, name string) []string {
+	keys := []string{}
+	for _, ing := range r.ingresses() {
+		if ing.Namespace != namespace {
+			continue
+		}
+		for _, tls := range ing.Spec.TLS {
+			if tls.SecretName == name {
+				key, _ := cache.MetaNamespaceKeyFunc(ing)
+				keys = append(keys, key)
+				break
+			}
+		}
+	}
+	return keys
+}
+
+// syncIngress brings the content vservers of the ingress with the given key
+// and the lb vservers they switch to in line with the desired configuration.
+// Content vservers the ingress no longer needs are removed, including those
+// of an ingress that now belongs to another ingress class.
+func (r *reconciler) syncIngress(key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+
+	r.mu.RLock()
+	if err := r.assignVIP(key); err != nil {
+		r.mu.RUnlock()
+		return err
+	}
+	cfg := r.desiredConfig()
+	errs := []error{}
+	removed := false
+	for _, csvserverName := range []string{GenerateCsVserverName(namespace, name), GenerateSslCsVserverName(namespace, name)} {
+		if csv, ok := cfg.CsVservers[csvserverName]; ok {
+			if err := r.applyCsVserver(cfg, csv); err != nil {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		// Content vservers of another cluster with the same name are left alone
+		if _, _, _, comment, err := GetContentVServer(csvserverName); err == nil && IsOwned(comment) {
+			log.Printf("Removing content vserver %s of ingress %s", csvserverName, key)
+			removed = true
+			if err := DeleteContentVServer(csvserverName); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	vip := ""
+	if csv, ok := cfg.CsVservers[GenerateCsVserverName(namespace, name)]; ok {
+		vip = csv.IP
+	}
+	r.mu.RUnlock()
+
+	for _, problem := range cfg.Problems[key] {
+		log.Printf("Ingress %s: %s", key, problem.Message)
+		r.eventf(key, api.EventTypeWarning, problem.Reason, "%s", problem.Message)
+	}
+	if len(errs) > 0 {
+		err := utilerrors.NewAggregate(errs)
+		r.recordSyncErrors(key, err)
+		return err
+	}
+
+	obj, exists, err := r.ingLister.GetByKey(key)
+	if err != nil {
+		return err
+	}
+	// The status of ingresses of other classes belongs to their controller,
+	// it is only cleared when we just removed our configuration
+	if ing, ok := obj.(*extensions.Ingress); exists && ok && (vip != "" || removed || r.classFilter.Matches(ing)) {
+		if err := r.updateIngressStatus(ing, vip); err != nil {
+			return err
+		}
+	}
+	if !removed {
+		return nil
+	}
+	return r.collectGarbage()
+}
+
+// eventf records an event on the ingress with the given key, if it still
+// exists.
+func (r *reconciler) eventf(ingressKey string, eventType string, reason string, format string, args ...interface{}) {
+	obj, exists, err := r.ingLister.GetByKey(ingressKey)
+	if err != nil || !exists {
+		return
+	}
+	r.recorder.Eventf(obj.(*extensions.Ingress), eventType, reason, format, args...)
+}
+
+// recordSyncErrors records a warning for each failure of a sync, NITRO
+// failures carry the errorcode and message of the NetScaler.
+func (r *reconciler) recordSyncErrors(ingressKey string, err error) {
+	if agg, ok := err.(utilerrors.Aggregate); ok {
+		for _, err := range agg.Errors() {
+			r.recordSyncErrors(ingressKey, err)
+		}
+		return
+	}
+	if nitroErr, ok := ParseNitroError(err); ok {
+		r.eventf(ingressKey, api.EventTypeWarning, reasonNitroError, "errorcode %d: %s", nitroErr.Errorcode, nitroErr.Message)
+		return
+	}
+	r.eventf(ingressKey, api.EventTypeWarning, reasonSyncFailed, "%v", err)
+}
+
+// updateIngressStatus publishes the VIP of the ingress in its load balancer
+// status, an empty vip clears it.
+func (r *reconciler) updateIngressStatus(ing *extensions.Ingress, vip string) error {
+	lbIngress := []api.LoadBalancerIngress{}
+	if vip != "" {
+		lbIngress = append(lbIngress, api.LoadBalancerIngress{IP: vip})
+	}
+	current := ing.Status.LoadBalancer.Ingress
+	if len(current) == len(lbIngress) && (len(current) == 0 || reflect.DeepEqual(current, lbIngress)) {
+		return nil
+	}
+
+	// Objects in the store are shared, update a fresh copy
+	latest, err := r.kubeClient.Extensions().Ingress(ing.Namespace).Get(ing.Name)
+	if err != nil {
+		return err
+	}
+	latest.Status.LoadBalancer.Ingress = lbIngress
+	if _, err := r.kubeClient.Extensions().Ingress(ing.Namespace).UpdateStatus(latest); err != nil {
+		log.Printf("Failed to update status of ingress %s/%s: %v", ing.Namespace, ing.Name, err)
+		return err
+	}
+	log.Printf("Published VIP %q in status of ingress %s/%s", vip, ing.Namespace, ing.Name)
+	return nil
+}
+
+// applyCsVserver configures the content vserver together with the lb vservers
+// it switches to and the certificates it terminates TLS with.
+func (r *reconciler) applyCsVserver(cfg *nsConfig, csv *csVserverConfig) error {
+	errs := []error{}
+	lbNames := sets.NewString()
+	for _, policy := range csv.Policies {
+		lbNames.Insert(policy.LbName)
+	}
+	if csv.DefaultLb != "" {
+		lbNames.Insert(csv.DefaultLb)
+	}
+	for _, lbName := range lbNames.List() {
+		if err := r.syncLbVserver(cfg, cfg.LbVservers[lbName]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, name := range csv.CertKeys {
+		if err := r.syncCertKey(cfg.CertKeys[name]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := r.syncCsVserver(csv); err != nil {
+		errs = append(errs, err)
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+func (r *reconciler) syncCertKey(certkey *certKeyConfig) error {
+	if err := UploadSslFile(certkey.CertFile, certkey.Cert); err != nil {
+		return err
+	}
+	if err := UploadSslFile(certkey.KeyFile, certkey.Key); err != nil {
+		return err
+	}
+	return AddOrUpdateCertKey(certkey.Name, certkey.CertFile, certkey.KeyFile)
+}
+
+// syncCertKeyBindings binds the certificates of an SSL vserver. Unbinding
+// happens first since a vserver only takes one non SNI certificate.
+func (r *reconciler) syncCertKeyBindings(csv *csVserverConfig) error {
+	if err := SetContentVServerSni(csv.Name, csv.SNI); err != nil {
+		return err
+	}
+	bound, err := ListBoundCertKeys(csv.Name)
+	if err != nil {
+		return err
+	}
+	desired := make(map[certKeyBinding]bool)
+	for _, binding := range csv.certKeyBindings() {
+		desired[binding] = true
+	}
+
+	errs := []error{}
+	for _, binding := range bound {
+		if desired[binding] {
+			continue
+		}
+		if err := UnbindCertKey(csv.Name, binding.Name, binding.SNI); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, binding := range csv.certKeyBindings() {
+		isBound := false
+		for _, b := range bound {
+			if b == binding {
+				isBound = true
+			}
+		}
+		if isBound {
+			continue
+		}
+		if err := BindCertKey(csv.Name, binding.Name, binding.SNI); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+func (r *reconciler) syncLbVserver(cfg *nsConfig, lbvserver *lbVserverConfig) error {
+	defer r.lbLocks.Lock(lbvserver.Name)()
+
+	if err := AddLbVServer(lbvserver.Name, lbvserver.Protocol, OwnerComment(lbvserver.Owner), lbvserver.Settings.lbVserver()); err != nil {
+		return err
+	}
+	bound, err := ListBoundServiceStates(lbvserver.Name)
+	if err != nil {
+		return err
+	}
+
+	// Services left from before the switch to servicegroup mode are unbound
+	services := lbvserver.Services
+	if lbvserver.Servicegroup != "" {
+		services = nil
+	}
+	errs := []error{}
+	for _, sname := range sets.StringKeySet(services).List() {
+		if state, ok := bound[sname]; ok {
+			// The endpoint became ready again while draining
+			if isDisabled(state) {
+				r.drains.Forget(sname)
+				if err := EnableService(sname); err != nil {
+					errs = append(errs, err)
+				}
+			}
+			continue
+		}
+		svc := services[sname]
+		if err := AddService(svc.Name, svc.IP, svc.Port, svc.Protocol, OwnerComment(svc.Owner), lbvserver.Settings.serverTimeout()); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := BindService(lbvserver.Name, svc.Name); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, sname := range sets.StringKeySet(bound).List() {
+		if _, ok := services[sname]; ok {
+			continue
+		}
+		drained, err := r.drains.Drain(sname, bound[sname], lbvserver.DrainTimeout, func(delay int) error {
+			return DisableService(sname, delay)
+		})
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !drained {
+			continue
+		}
+		if err := UnbindService(lbvserver.Name, sname); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !cfg.Services.Has(sname) {
+			if err := r.releaseMonitors(cfg, sname); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			if err := DeleteService(sname); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	if lbvserver.Servicegroup != "" {
+		if err := r.syncServicegroup(cfg, lbvserver); err != nil {
+			errs = append(errs, err)
+		}
+	} else if err := r.syncMonitors(cfg, lbvserver); err != nil {
+		errs = append(errs, err)
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// syncServicegroup brings the servicegroup of the lb vserver in line with its
+// endpoints, so that scaling only adds and removes members. A servicegroup has
+// a single monitor for all members, the monitor of the first endpoint that
+// has one is used.
+func (r *reconciler) syncServicegroup(cfg *nsConfig, lbvserver *lbVserverConfig) error {
+	sgName := lbvserver.Servicegroup
+	if err := AddServicegroup(sgName, lbvserver.Protocol, OwnerComment(lbvserver.Owner), lbvserver.Settings.serverTimeout()); err != nil {
+		return err
+	}
+	boundGroups, err := ListBoundServicegroupsForLB(lbvserver.Name)
+	if err != nil {
+		return err
+	}
+	if !sets.NewString(boundGroups...).Has(sgName) {
+		if err := BindServicegroup(lbvserver.Name, sgName); err != nil {
+			return err
+		}
+	}
+
+	members, err := ListServicegroupMembers(sgName)
+	if err != nil {
+		return err
+	}
+	desired := make(map[servicegroupMember]bool)
+	monitorName := ""
+	for _, sname := range sets.StringKeySet(lbvserver.Services).List() {
+		svc := lbvserver.Services[sname]
+		desired[servicegroupMember{IP: svc.IP, Port: svc.Port}] = true
+		if monitorName == "" {
+			monitorName = svc.Monitor
+		}
+	}
+
+	errs := []error{}
+	for member, state := range members {
+		if desired[member] {
+			continue
+		}
+		key := fmt.Sprintf("%s/%s:%d", sgName, member.IP, member.Port)
+		drained, err := r.drains.Drain(key, state, lbvserver.DrainTimeout, func(delay int) error {
+			return DisableServicegroupMember(sgName, member, delay)
+		})
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !drained {
+			continue
+		}
+		if err := DeleteServicegroupMember(sgName, member); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, sname := range sets.StringKeySet(lbvserver.Services).List() {
+		svc := lbvserver.Services[sname]
+		member := servicegroupMember{IP: svc.IP, Port: svc.Port}
+		state, ok := members[member]
+		if ok && isDisabled(state) {
+			// The endpoint became ready again while draining
+			r.drains.Forget(fmt.Sprintf("%s/%s:%d", sgName, member.IP, member.Port))
+			if err := EnableServicegroupMember(sgName, member); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		if ok {
+			continue
+		}
+		if err := AddServicegroupMember(sgName, member); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	boundMonitors, err := ListBoundMonitorsForServicegroup(sgName)
+	if err != nil {
+		return err
+	}
+	for _, name := range boundMonitors {
+		if name == monitorName {
+			continue
+		}
+		if err := r.unbindServicegroupMonitor(cfg, sgName, name); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if monitorName != "" && !sets.NewString(boundMonitors...).Has(monitorName) {
+		if err := AddMonitor(cfg.Monitors[monitorName].lbMonitor()); err != nil {
+			errs = append(errs, err)
+		} else if err := BindServicegroupMonitor(sgName, monitorName); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// syncMonitors binds the services of the lb vserver to their monitors, and
+// unbinds services that switched to another monitor. Monitors no service
+// wants anymore are left to garbage collection.
+func (r *reconciler) syncMonitors(cfg *nsConfig, lbvserver *lbVserverConfig) error {
+	wanted := make(map[string][]string)
+	for _, sname := range sets.StringKeySet(lbvserver.Services).List() {
+		if monitorName := lbvserver.Services[sname].Monitor; monitorName != "" {
+			wanted[monitorName] = append(wanted[monitorName], sname)
+		}
+	}
+
+	errs := []error{}
+	for _, monitorName := range sets.StringKeySet(wanted).List() {
+		if err := AddMonitor(cfg.Monitors[monitorName].lbMonitor()); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		bound, err := ListBoundServicesForMonitor(monitorName)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, sname := range bound {
+			if svc, ok := lbvserver.Services[sname]; ok && svc.Monitor != monitorName {
+				if err := r.unbindMonitor(cfg, sname, monitorName); err != nil {
+					errs = append(errs, err)
+				}
+			}
+		}
+		boundSet := sets.NewString(bound...)
+		for _, sname := range wanted[monitorName] {
+			if boundSet.Has(sname) {
+				continue
+			}
+			if err := BindMonitor(sname, monitorName); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+func (r *reconciler) syncCsVserver(csv *csVserverConfig) error {
+	owner := OwnerComment(csv.Owner)
+	ip, port, protocol, comment, err := GetContentVServer(csv.Name)
+	if err != nil {
+		if err := CreateContentVServer(csv.Name, csv.IP, csv.Port, csv.Protocol, owner); err != nil {
+			return err
+		}
+		r.eventf(csv.Owner, api.EventTypeNormal, reasonCreatedCSVserver, "Created content vserver %s on %s:%d", csv.Name, csv.IP, csv.Port)
+	} else if !CanAdopt(comment) {
+		return fmt.Errorf("content vserver %s is not managed by this controller (comment %q)", csv.Name, comment)
+	} else if !strings.EqualFold(protocol, csv.Protocol) {
+		// The service type of a content vserver cannot be changed in place
+		log.Printf("Recreating content vserver %s with protocol %s", csv.Name, csv.Protocol)
+		if err := DeleteContentVServer(csv.Name); err != nil {
+			return err
+		}
+		if err := CreateContentVServer(csv.Name, csv.IP, csv.Port, csv.Protocol, owner); err != nil {
+			return err
+		}
+	} else if ip != csv.IP || port != csv.Port || comment != owner {
+		if err := UpdateContentVServer(csv.Name, csv.IP, csv.Port, owner); err != nil {
+			return err
+		}
+	}
+
+	if csv.Protocol == "SSL" {
+		if err := r.syncCertKeyBindings(csv); err != nil {
+			return err
+		}
+	}
+
+	if err := syncDefaultLb(csv); err != nil {
+		return err
+	}
+
+	bound, err := ListBoundPolicies(csv.Name)
+	if err != nil {
+		return err
+	}
+
+	// Stale policies and policies bound with the wrong priority are unbound
+	// first, so that they don't hold priorities other policies need
+	errs := []error{}
+	desired := make(map[string]int)
+	for i, policy := range csv.Policies {
+		desired[policy.Name] = policyPriority(i)
+	}
+	for policyName, prio := range bound {
+		want, ok := desired[policyName]
+		if !ok {
+			if _, err := DeleteContentVServerPolicy(csv.Name, policyName); err != nil {
+				errs = append(errs, err)
+			}
+			delete(bound, policyName)
+		} else if prio != want {
+			log.Printf("Rebinding policy %s of content vserver %s from priority %d to %d", policyName, csv.Name, prio, want)
+			if err := UnbindCsPolicy(csv.Name, policyName); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			delete(bound, policyName)
+		}
+	}
+
+	for _, policy := range csv.Policies {
+		if err := AddOrUpdateCsAction(policy.Action, policy.LbName, OwnerComment(policy.Owner)); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := AddOrUpdateCsPolicy(policy.Name, policy.Rule, policy.Action); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if _, ok := bound[policy.Name]; ok {
+			continue
+		}
+		priority := desired[policy.Name]
+		log.Printf("Configure Netscaler: content vserver: %s policy: %s lb: %s priority %d", csv.Name, policy.Name, policy.LbName, priority)
+		if err := BindCsPolicy(csv.Name, policy.Name, priority); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		r.eventf(policy.Owner, api.EventTypeNormal, reasonPolicyBound, "Bound policy %s to content vserver %s, switching to %s", policy.Name, csv.Name, policy.LbName)
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// syncDefaultLb binds the default lb vserver of the content vserver, replacing
+// the one bound before.
+func syncDefaultLb(csv *csVserverConfig) error {
+	current, err := GetDefaultLbVserver(csv.Name)
+	if err != nil {
+		return err
+	}
+	if current == csv.DefaultLb {
+		return nil
+	}
+	if current != "" {
+		if err := UnbindDefaultLbVserver(csv.Name, current); err != nil {
+			return err
+		}
+	}
+	if csv.DefaultLb == "" {
+		return nil
+	}
+	log.Printf("Configure Netscaler: content vserver: %s default lb: %s", csv.Name, csv.DefaultLb)
+	return BindDefaultLbVserver(csv.Name, csv.DefaultLb)
+}
+
+// collectGarbage removes the content vservers, cs actions, lb vservers,
+// services, servicegroups, monitors and certkeys created by the controller
+// that are no longer part of the desired configuration. Only objects carrying
+// the ownership marker of this cluster are considered.
+func (r *reconciler) collectGarbage() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cfg := r.desiredConfig()
+	errs := []error{}
+
+	csvservers, err := ListOwnedContentVservers()
+	if err != nil {
+		return err
+	}
+	for _, name := range csvservers {
+		if _, ok := cfg.CsVservers[name]; ok {
+			continue
+		}
+		log.Printf("Removing stale content vserver %s", name)
+		if err := DeleteContentVServer(name); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	// Actions, and their policies, left behind by an interrupted removal
+	// would keep their lb vservers from being removed
+	actions := sets.NewString()
+	for _, csv := range cfg.CsVservers {
+		for _, policy := range csv.Policies {
+			actions.Insert(policy.Action)
+		}
+	}
+	csactions, err := ListOwnedCsActions()
+	if err != nil {
+		return err
+	}
+	for _, name := range csactions {
+		if actions.Has(name) {
+			continue
+		}
+		log.Printf("Removing stale cs action %s", name)
+		if err := DeleteCsAction(name); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	lbvservers, err := ListOwnedLbVservers()
+	if err != nil {
+		return err
+	}
+	for _, name := range lbvservers {
+		if _, ok := cfg.LbVservers[name]; ok {
+			continue
+		}
+		log.Printf("Removing stale lb vserver %s", name)
+		serviceNames, err := ListBoundServicesForLB(name)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, sname := range serviceNames {
+			if err := UnbindService(name, sname); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		if err := DeleteLbVServer(name); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	services, err := ListOwnedServices()
+	if err != nil {
+		return err
+	}
+	// Services are created with the server timeout of their lb vserver,
+	// changes to it are applied here
+	serverTimeouts := make(map[string]int)
+	for _, lbvserver := range cfg.LbVservers {
+		if lbvserver.Servicegroup != "" {
+			continue
+		}
+		for _, svc := range lbvserver.Services {
+			serverTimeouts[svc.Name] = lbvserver.Settings.serverTimeout()
+		}
+	}
+	for name, record := range services {
+		if cfg.Services.Has(name) {
+			if timeout, ok := serverTimeouts[name]; ok && fmt.Sprint(record["svrtimeout"]) != strconv.Itoa(timeout) {
+				if err := SetServiceTimeout(name, timeout); err != nil {
+					errs = append(errs, err)
+				}
+			}
+			continue
+		}
+		state, err := GetServiceState(name)
+		if err != nil {
+			continue
+		}
+		drained, err := r.drains.Drain(name, state, r.drainTimeout, func(delay int) error {
+			return DisableService(name, delay)
+		})
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !drained {
+			continue
+		}
+		if err := r.releaseMonitors(cfg, name); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		log.Printf("Removing stale service %s", name)
+		if err := DeleteService(name); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	servicegroups, err := ListOwnedServicegroups()
+	if err != nil {
+		return err
+	}
+	for _, name := range servicegroups {
+		if cfg.Servicegroups.Has(name) {
+			continue
+		}
+		if err := r.releaseServicegroupMonitors(cfg, name); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		log.Printf("Removing stale servicegroup %s", name)
+		if err := DeleteServicegroup(name); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	monitors, err := ListMonitors()
+	if err != nil {
+		return err
+	}
+	// Monitors have no comment either. Stale monitors named like ours are
+	// unbound from our services and servicegroups, and removed once that
+	// leaves them unused
+	ownedServices := sets.NewString()
+	for name := range services {
+		ownedServices.Insert(name)
+	}
+	ownedServicegroups := sets.NewString(servicegroups...)
+	for name := range monitors {
+		if _, ok := cfg.Monitors[name]; ok || !strings.HasPrefix(name, "mon_") {
+			continue
+		}
+		bound, err := ListBoundServicesForMonitor(name)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, sname := range bound {
+			if !ownedServices.Has(sname) {
+				continue
+			}
+			if err := r.unbindMonitor(cfg, sname, name); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		boundGroups, err := ListBoundServicegroupsForMonitor(name)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, sgName := range boundGroups {
+			if !ownedServicegroups.Has(sgName) {
+				continue
+			}
+			if err := r.unbindServicegroupMonitor(cfg, sgName, name); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	certkeys, err := ListCertKeys()
+	if err != nil {
+		return err
+	}
+	// Certkeys have no comment to carry the marker, the ones named after
+	// this cluster are only removed once no SSL vserver uses them anymore
+	for _, name := range certkeys {
+		if _, ok := cfg.CertKeys[name]; ok || !IsOwnedCertKey(name) {
+			continue
+		}
+		if inUse, err := CertKeyInUse(name); err != nil || inUse {
+			if err != nil {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		log.Printf("Removing stale certkey %s", name)
+		if err := DeleteCertKey(name); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	// VIPs of ingresses deleted while no sync ran are released here
+	for _, key := range r.vips.Keys() {
+		if needed, err := r.needsVIP(key); err != nil || needed {
+			continue
+		}
+		if err := r.vips.Release(key); err != nil {
+			errs = append(errs, err)
+		}
+		r.configs.Invalidate()
+	}
+	return utilerrors.NewAggregate(errs)
+}
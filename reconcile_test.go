@@ -0,0 +1,182 @@
+/*
+Copyright 2016 Citrix Systems, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	"k8s.io/kubernetes/pkg/client/cache"
+	"k8s.io/kubernetes/pkg/util/intstr"
+)
+
+// newBackendTestReconciler returns a reconciler whose stores hold the
+// services and endpoints the backend tests refer to.
+func newBackendTestReconciler() *reconciler {
+	svcStore := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	epStore := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	svcStore.Add(&api.Service{
+		ObjectMeta: api.ObjectMeta{Namespace: "default", Name: "web"},
+		Spec: api.ServiceSpec{Ports: []api.ServicePort{
+			{Name: "http", Port: 80, TargetPort: intstr.FromInt(8080)},
+			{Name: "metrics", Port: 9090, TargetPort: intstr.FromString("metrics")},
+		}},
+	})
+	epStore.Add(&api.Endpoints{
+		ObjectMeta: api.ObjectMeta{Namespace: "default", Name: "web"},
+		Subsets: []api.EndpointSubset{{
+			Addresses: []api.EndpointAddress{
+				{IP: "10.0.0.1", TargetRef: &api.ObjectReference{Kind: "Pod", Namespace: "default", Name: "web-1"}},
+				{IP: "10.0.0.2"},
+			},
+			Ports: []api.EndpointPort{
+				{Name: "http", Port: 8080},
+				{Name: "metrics", Port: 9100},
+			},
+		}},
+	})
+	svcStore.Add(&api.Service{
+		ObjectMeta: api.ObjectMeta{Namespace: "default", Name: "single"},
+		Spec:       api.ServiceSpec{Ports: []api.ServicePort{{Port: 80, TargetPort: intstr.FromInt(8000)}}},
+	})
+	epStore.Add(&api.Endpoints{
+		ObjectMeta: api.ObjectMeta{Namespace: "default", Name: "single"},
+		Subsets: []api.EndpointSubset{{
+			Addresses: []api.EndpointAddress{{IP: "10.0.1.1"}},
+			Ports:     []api.EndpointPort{{Port: 8000}},
+		}},
+	})
+	svcStore.Add(&api.Service{
+		ObjectMeta: api.ObjectMeta{Namespace: "default", Name: "noendpoints"},
+		Spec:       api.ServiceSpec{Ports: []api.ServicePort{{Port: 80}}},
+	})
+	return &reconciler{
+		svcLister: cache.StoreToServiceLister{Store: svcStore},
+		epLister:  cache.StoreToEndpointsLister{Store: epStore},
+		podLister: cache.StoreToPodLister{Store: cache.NewStore(cache.MetaNamespaceKeyFunc)},
+	}
+}
+
+func TestBackendEndpoints(t *testing.T) {
+	r := newBackendTestReconciler()
+
+	http := []endpointAddress{{IP: "10.0.0.1", Port: 8080, Pod: "default/web-1"}, {IP: "10.0.0.2", Port: 8080}}
+	tests := []struct {
+		name      string
+		namespace string
+		service   string
+		port      intstr.IntOrString
+		want      []endpointAddress
+		wantErr   bool
+	}{
+		{name: "port number", namespace: "default", service: "web", port: intstr.FromInt(80), want: http},
+		{name: "port name", namespace: "default", service: "web", port: intstr.FromString("http"), want: http},
+		{
+			name: "other port name", namespace: "default", service: "web", port: intstr.FromString("metrics"),
+			want: []endpointAddress{{IP: "10.0.0.1", Port: 9100, Pod: "default/web-1"}, {IP: "10.0.0.2", Port: 9100}},
+		},
+		{
+			name: "unnamed port", namespace: "default", service: "single", port: intstr.FromInt(80),
+			want: []endpointAddress{{IP: "10.0.1.1", Port: 8000}},
+		},
+		{name: "target port number", namespace: "default", service: "web", port: intstr.FromInt(8080), wantErr: true},
+		{name: "unknown port name", namespace: "default", service: "web", port: intstr.FromString("grpc"), wantErr: true},
+		{name: "other namespace", namespace: "other", service: "web", port: intstr.FromInt(80), wantErr: true},
+		{name: "no endpoints", namespace: "default", service: "noendpoints", port: intstr.FromInt(80), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		backend := extensions.IngressBackend{ServiceName: tt.service, ServicePort: tt.port}
+		got, err := r.backendEndpoints(tt.namespace, backend)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %v", tt.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAddBackendConfigNames(t *testing.T) {
+	r := newBackendTestReconciler()
+	r.servicegroups = true
+	cfg := newNsConfig()
+	opts := &backendOptions{}
+	tests := []struct {
+		service string
+		port    intstr.IntOrString
+		want    string
+	}{
+		{"web", intstr.FromInt(80), "lb_default_web_80"},
+		// Both refer to the same service port and share the lb vserver
+		{"web", intstr.FromString("http"), "lb_default_web_80"},
+		{"web", intstr.FromString("metrics"), "lb_default_web_9090"},
+		// Unknown services keep the port of the backend
+		{"missing", intstr.FromString("http"), "lb_default_missing_http"},
+	}
+
+	for _, tt := range tests {
+		backend := extensions.IngressBackend{ServiceName: tt.service, ServicePort: tt.port}
+		if got := r.addBackendConfig(cfg, "default/ing", "default", backend, opts); got != tt.want {
+			t.Errorf("addBackendConfig(%s, %s) = %s, want %s", tt.service, tt.port.String(), got, tt.want)
+		}
+	}
+	if got := cfg.Servicegroups.List(); !reflect.DeepEqual(got, []string{"sg_default_missing_http", "sg_default_web_80", "sg_default_web_9090"}) {
+		t.Errorf("servicegroups = %v", got)
+	}
+	if got := len(cfg.LbVservers["lb_default_web_80"].Services); got != 2 {
+		t.Errorf("lb_default_web_80 has %d services, want 2", got)
+	}
+}
+
+func TestIngressClassFilter(t *testing.T) {
+	withClass := func(class string) *extensions.Ingress {
+		return &extensions.Ingress{ObjectMeta: api.ObjectMeta{
+			Annotations: map[string]string{ingressClassAnnotation: class},
+		}}
+	}
+	classless := &extensions.Ingress{}
+	tests := []struct {
+		name   string
+		filter ingressClassFilter
+		ing    *extensions.Ingress
+		want   bool
+	}{
+		{"own class", ingressClassFilter{Class: "citrix"}, withClass("citrix"), true},
+		{"other class", ingressClassFilter{Class: "citrix", ClaimClassless: true}, withClass("nginx"), false},
+		{"classless claimed", ingressClassFilter{Class: "citrix", ClaimClassless: true}, classless, true},
+		{"classless not claimed", ingressClassFilter{Class: "citrix"}, classless, false},
+		{"empty class claimed", ingressClassFilter{Class: "citrix", ClaimClassless: true}, withClass(""), true},
+		{"empty class not claimed", ingressClassFilter{Class: "citrix"}, withClass(""), false},
+		{"class is case sensitive", ingressClassFilter{Class: "citrix"}, withClass("Citrix"), false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.filter.Matches(tt.ing); got != tt.want {
+			t.Errorf("%s: Matches() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
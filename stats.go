@@ -0,0 +1,181 @@
+/*
+Copyright 2016 Citrix Systems, Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/chiradeep/go-nitro/netscaler"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/kubernetes/pkg/client/cache"
+)
+
+var (
+	vserverLabels = []string{"vserver", "type", "namespace", "ingress", "service"}
+	serviceLabels = []string{"name", "namespace", "ingress", "service", "pod_ip"}
+)
+
+// nitroStat maps a counter of the NITRO stat API to a metric.
+type nitroStat struct {
+	field     string
+	desc      *prometheus.Desc
+	valueType prometheus.ValueType
+}
+
+func newNitroStat(field string, name string, help string, valueType prometheus.ValueType, labels []string) nitroStat {
+	return nitroStat{
+		field:     field,
+		desc:      prometheus.NewDesc(metricsNamespace+"_"+name, help, labels, nil),
+		valueType: valueType,
+	}
+}
+
+var (
+	vserverStats = []nitroStat{
+		newNitroStat("tothits", "vserver_hits_total", "Requests hitting the vserver.", prometheus.CounterValue, vserverLabels),
+		newNitroStat("curclntconnections", "vserver_current_client_connections", "Open client connections of the vserver.", prometheus.GaugeValue, vserverLabels),
+		newNitroStat("totalrequestbytes", "vserver_request_bytes_total", "Request bytes received by the vserver.", prometheus.CounterValue, vserverLabels),
+		newNitroStat("totalresponsebytes", "vserver_response_bytes_total", "Response bytes sent by the vserver.", prometheus.CounterValue, vserverLabels),
+		newNitroStat("surgecount", "vserver_surge_queue_length", "Requests waiting in the surge queue of the vserver.", prometheus.GaugeValue, vserverLabels),
+	}
+	vserverUp    = prometheus.NewDesc(metricsNamespace+"_vserver_up", "Whether the vserver is UP.", vserverLabels, nil)
+	serviceStats = []nitroStat{
+		newNitroStat("totalrequests", "service_requests_total", "Requests sent to the pod.", prometheus.CounterValue, serviceLabels),
+		newNitroStat("curclntconnections", "service_current_client_connections", "Open client connections to the pod.", prometheus.GaugeValue, serviceLabels),
+		newNitroStat("totalrequestbytes", "service_request_bytes_total", "Request bytes sent to the pod.", prometheus.CounterValue, serviceLabels),
+		newNitroStat("totalresponsebytes", "service_response_bytes_total", "Response bytes received from the pod.", prometheus.CounterValue, serviceLabels),
+		newNitroStat("surgecount", "service_surge_queue_length", "Requests waiting in the surge queue of the pod.", prometheus.GaugeValue, serviceLabels),
+	}
+	serviceUp = prometheus.NewDesc(metricsNamespace+"_service_up", "Whether the NetScaler considers the pod UP.", serviceLabels, nil)
+)
+
+// statsCollector exports the statistics of the NetScaler objects the
+// controller manages. The statistics are polled periodically and the last
+// poll is served on every scrape, so scrapes never wait for the NetScaler.
+type statsCollector struct {
+	mu      sync.Mutex
+	metrics []prometheus.Metric
+}
+
+func newStatsCollector() *statsCollector {
+	c := &statsCollector{}
+	prometheus.MustRegister(c)
+	return c
+}
+
+func (c *statsCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, stat := range vserverStats {
+		ch <- stat.desc
+	}
+	ch <- vserverUp
+	for _, stat := range serviceStats {
+		ch <- stat.desc
+	}
+	ch <- serviceUp
+}
+
+func (c *statsCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, m := range c.metrics {
+		ch <- m
+	}
+}
+
+// Poll reads the statistics of the content vservers, lb vservers and
+// services in the desired configuration.
+func (c *statsCollector) Poll(r *reconciler) {
+	r.mu.RLock()
+	cfg := r.desiredConfig()
+	r.mu.RUnlock()
+
+	metrics := []prometheus.Metric{}
+	csLabels := make(map[string][]string)
+	for name, csv := range cfg.CsVservers {
+		namespace, ingress, _ := cache.SplitMetaNamespaceKey(csv.Owner)
+		csLabels[name] = []string{name, "cs", namespace, ingress, ""}
+	}
+	lbLabels := make(map[string][]string)
+	svcLabels := make(map[string][]string)
+	for name, lb := range cfg.LbVservers {
+		_, ingress, _ := cache.SplitMetaNamespaceKey(lb.Owner)
+		lbLabels[name] = []string{name, "lb", lb.Namespace, ingress, lb.ServiceName}
+		for sname, svc := range lb.Services {
+			svcLabels[sname] = []string{sname, lb.Namespace, ingress, lb.ServiceName, svc.IP}
+		}
+	}
+
+	for _, source := range []struct {
+		resourceType string
+		labels       map[string][]string
+		stats        []nitroStat
+		up           *prometheus.Desc
+	}{
+		{netscaler.Csvserver.Type(), csLabels, vserverStats, vserverUp},
+		{netscaler.Lbvserver.Type(), lbLabels, vserverStats, vserverUp},
+		{netscaler.Service.Type(), svcLabels, serviceStats, serviceUp},
+	} {
+		stats, err := ListStats(source.resourceType)
+		if err != nil {
+			// Keep serving the last poll rather than dropping the series
+			return
+		}
+		for _, stat := range stats {
+			name, _ := stat["name"].(string)
+			labels, ok := source.labels[name]
+			if !ok {
+				continue
+			}
+			for _, s := range source.stats {
+				value, ok := statValue(stat[s.field])
+				if !ok {
+					continue
+				}
+				metrics = append(metrics, prometheus.MustNewConstMetric(s.desc, s.valueType, value, labels...))
+			}
+			up := 0.0
+			if strings.EqualFold(fmt.Sprint(stat["state"]), "UP") {
+				up = 1
+			}
+			metrics = append(metrics, prometheus.MustNewConstMetric(source.up, prometheus.GaugeValue, up, labels...))
+		}
+	}
+
+	c.mu.Lock()
+	c.metrics = metrics
+	c.mu.Unlock()
+}
+
+// statValue parses a NITRO statistic, counters are returned as strings.
+func statValue(v interface{}) (float64, bool) {
+	switch value := v.(type) {
+	case float64:
+		return value, true
+	case string:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			log.Printf("Ignoring malformed NITRO statistic %q", value)
+			return 0, false
+		}
+		return f, true
+	}
+	return 0, false
+}